@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// activeDose is the estimated amount of a dose in the bloodstream over time.
+// Absorption is modeled as linear from the onset until the peak, and elimination as first-order after the peak.
+type activeDose struct {
+	Start  time.Time
+	Amount float64       // the bioavailable amount, in the Unit of the activeDrug
+	Onset  time.Duration // until absorption starts
+	Peak   time.Duration // until everything is absorbed, always at least Onset
+}
+
+// At returns the amount of d left at t, using the half-life of its drug
+func (d activeDose) At(t time.Time, halfLife time.Duration) float64 {
+	elapsed := t.Sub(d.Start)
+	switch {
+	case elapsed < d.Onset:
+		return 0
+	case elapsed < d.Peak:
+		return d.Amount * float64(elapsed-d.Onset) / float64(d.Peak-d.Onset)
+	default:
+		return d.Amount * math.Pow(0.5, float64(elapsed-d.Peak)/float64(halfLife))
+	}
+}
+
+// activeDrug is every dose of a drug that is modeled by -active
+type activeDrug struct {
+	Drug       string
+	Unit       Unit
+	HalfLife   time.Duration
+	Duration   time.Duration // how long the effects last, 0 if it isn't known
+	Threshold  float64       // in Unit, see activeThreshold
+	LastAmount float64       // the bioavailable amount of the last dose, in Unit
+	LastEffect time.Time     // when the effects of the last dose started
+	Below      time.Time     // when the amount drops below Threshold, see DropsBelow
+	Doses      []activeDose  // sorted by Start
+}
+
+// At returns the total amount of the drug left at t
+func (a activeDrug) At(t time.Time) float64 {
+	total := 0.0
+	for _, d := range a.Doses {
+		total += d.At(t, a.HalfLife)
+	}
+
+	return total
+}
+
+// Peak returns when the amount of the drug is highest, from now onwards if any doses haven't peaked yet.
+// Amounts only rise until a dose peaks, so the highest amount is always at the peak of one of the doses.
+func (a activeDrug) Peak(now time.Time) (time.Time, float64) {
+	peak, amount := time.Time{}, 0.0
+	upcoming := false
+
+	for _, d := range a.Doses {
+		t := d.Start.Add(d.Peak)
+		if !upcoming && t.After(now) {
+			// once a dose peaks in the future, only look at future peaks
+			peak, amount, upcoming = time.Time{}, 0, true
+		}
+
+		if upcoming && !t.After(now) {
+			continue
+		}
+
+		if total := a.At(t); peak.IsZero() || total >= amount {
+			peak, amount = t, total
+		}
+	}
+
+	return peak, amount
+}
+
+// DropsBelow returns when the amount of the drug drops below the threshold, and stays below it
+func (a activeDrug) DropsBelow(now time.Time) time.Time {
+	last := now
+	for _, d := range a.Doses {
+		if t := d.Start.Add(d.Peak); t.After(last) {
+			last = t
+		}
+	}
+
+	// Until the last dose peaks the amount can still rise, so step through it a minute at a time
+	below := time.Time{}
+	for t := now; t.Before(last); t = t.Add(time.Minute) {
+		if a.At(t) >= a.Threshold {
+			below = time.Time{}
+		} else if below.IsZero() {
+			below = t
+		}
+	}
+
+	remaining := a.At(last)
+	if remaining < a.Threshold {
+		if below.IsZero() {
+			below = last
+		}
+
+		return below
+	}
+
+	// Every dose has peaked, and they all have the same half-life, so the total decays with it as well
+	return last.Add(time.Duration(float64(a.HalfLife) * math.Log2(remaining/a.Threshold)))
+}
+
+// activeThreshold is the amount that a drug is considered active above, from -threshold
+type activeThreshold struct {
+	Fraction float64 // of the last dose, eg "5%"
+	Amount   float64 // in Unit, eg "10mg"
+	Unit     Unit
+}
+
+func parseActiveThreshold(s string) (activeThreshold, error) {
+	s = strings.TrimSpace(s)
+
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err != nil || percent <= 0 || percent >= 100 {
+			return activeThreshold{}, fmt.Errorf("\"%s\" is not a percentage between 0%% and 100%%", s)
+		}
+
+		return activeThreshold{Fraction: percent / 100}, nil
+	}
+
+	quantity, err := parseQuantity(s)
+	if err != nil {
+		return activeThreshold{}, err
+	}
+
+	if quantity.Amount() <= 0 {
+		return activeThreshold{}, fmt.Errorf("\"%s\" must be more than 0", s)
+	}
+
+	return activeThreshold{Amount: quantity.Amount(), Unit: lookupUnit(quantity.Unit)}, nil
+}
+
+// For returns the threshold for a in its unit, or false if the threshold is in a unit that a can't be converted to
+func (t activeThreshold) For(a activeDrug) (float64, bool) {
+	if t.Fraction != 0 {
+		return a.LastAmount * t.Fraction, true
+	}
+
+	amount, err := t.Unit.Convert(t.Amount, a.Unit)
+	return amount, err == nil
+}
+
+// activeDrugs models every dose of each drug that has a half-life in the substance database.
+// The names of drugs without a half-life are returned as well, if they were dosed in the last day.
+func activeDrugs(doses []Dose, threshold activeThreshold, now time.Time) ([]activeDrug, []string) {
+	// Drugs are keyed by drugKey, so that aliases and different cases of a drug are modeled together
+	drugs := make(map[string]*activeDrug)
+	order := make([]string, 0)
+	unknown := make(map[string]string)
+
+	for _, d := range doses {
+		key := drugKey(d.Drug)
+		substance := config.substances.Get(d.Drug)
+		if substance == nil || substance.halfLife == 0 {
+			if _, ok := unknown[key]; !ok && now.Sub(d.Timestamp) < 24*time.Hour {
+				unknown[key] = d.Drug
+				if substance != nil {
+					unknown[key] = substance.Name
+				}
+			}
+
+			continue
+		}
+
+		a, ok := drugs[key]
+		if !ok {
+			a = &activeDrug{Drug: substance.Name, HalfLife: substance.halfLife, Duration: substance.duration}
+			drugs[key] = a
+			order = append(order, key)
+		}
+
+		// The unit of the first dose is used for every dose, liquids with a density are modeled by mass
+		if a.Unit.Name == "" {
+			_, unit, err := d.Total()
+			if err != nil {
+				continue
+			}
+
+			a.Unit = lookupUnit(unit)
+			if a.Unit.Family == UnitFamilyVolume && substance.Density != 0 {
+				a.Unit = lookupUnit("mg")
+			}
+		}
+
+		amount, ok := d.AmountIn(a.Unit)
+		if !ok || amount <= 0 {
+			continue
+		}
+
+		if fraction, ok := substance.AvailableFraction(d.RoA); ok {
+			amount *= fraction
+		}
+
+		// Doses that aren't instantaneous, eg an infusion or a patch, are absorbed over their duration
+		peak := substance.peak
+		if peak < substance.onset {
+			peak = substance.onset
+		}
+
+		a.Doses = append(a.Doses, activeDose{Start: d.Timestamp, Amount: amount, Onset: substance.onset, Peak: peak + d.ParsedDuration()})
+		if effect := d.Timestamp.Add(substance.onset); effect.After(a.LastEffect) {
+			a.LastAmount, a.LastEffect = amount, effect
+		}
+	}
+
+	active := make([]activeDrug, 0)
+	for _, drug := range order {
+		a := drugs[drug]
+		if len(a.Doses) == 0 {
+			continue
+		}
+
+		t, ok := threshold.For(*a)
+		if !ok || t <= 0 {
+			continue
+		}
+
+		a.Threshold = t
+
+		// Doses that are almost eliminated don't change the estimate, and would be picked as the peak
+		relevant := make([]activeDose, 0)
+		for _, d := range a.Doses {
+			if d.Start.Add(d.Peak).After(now) || d.At(now, a.HalfLife) >= t/1000 {
+				relevant = append(relevant, d)
+			}
+		}
+
+		a.Doses = relevant
+		if len(a.Doses) == 0 {
+			continue
+		}
+
+		sort.SliceStable(a.Doses, func(i, j int) bool {
+			return a.Doses[i].Start.Before(a.Doses[j].Start)
+		})
+
+		if _, peak := a.Peak(now); a.At(now) < t && peak < t {
+			continue
+		}
+
+		// DropsBelow steps through every minute until the last dose peaks, so it's only calculated once for each drug
+		a.Below = a.DropsBelow(now)
+		active = append(active, *a)
+	}
+
+	// Drugs that will be active for the longest are at the bottom, the same as stats
+	sort.SliceStable(active, func(i, j int) bool {
+		return active[i].Below.Before(active[j].Below)
+	})
+
+	names := make([]string, 0)
+	for _, drug := range unknown {
+		names = append(names, drug)
+	}
+
+	sort.Strings(names)
+	return active, names
+}
+
+// Format returns a summary of a, eg "~85mg Caffeine, peaked 09:45 (~180mg), below 9mg at 23:10 (in 13h20m)"
+func (a activeDrug) Format(now time.Time) string {
+	parts := []string{"~" + formatDrugAmount(a.At(now), a.Unit, a.Drug) + " " + a.Drug}
+
+	if a.LastEffect.After(now) {
+		parts = append(parts, "kicks in at "+formatNearTime(a.LastEffect, now))
+	}
+
+	peak, amount := a.Peak(now)
+	if peak.After(now) {
+		parts = append(parts, fmt.Sprintf("peaks at %s (~%s)", formatNearTime(peak, now), formatDrugAmount(amount, a.Unit, a.Drug)))
+	} else {
+		parts = append(parts, fmt.Sprintf("peaked at %s (~%s)", formatNearTime(peak, now), formatDrugAmount(amount, a.Unit, a.Drug)))
+	}
+
+	parts = append(parts, fmt.Sprintf("below %s at %s (in %s)", formatDrugAmount(a.Threshold, a.Unit, a.Drug), formatNearTime(a.Below, now), formatDuration(a.Below.Sub(now).Round(time.Minute))))
+
+	// The effects are counted from the onset of the last dose, as that's when they start
+	if effects := a.LastEffect.Add(a.Duration); a.Duration > 0 && effects.After(now) {
+		parts = append(parts, "effects until "+formatNearTime(effects, now))
+	}
+
+	return strings.Join(parts, ", ")
+}
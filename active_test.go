@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestActiveDoseAt(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	d := activeDose{Start: start, Amount: 100, Onset: 30 * time.Minute, Peak: 90 * time.Minute}
+
+	tests := []struct {
+		elapsed time.Duration
+		want    float64
+	}{
+		{-time.Hour, 0},
+		{0, 0},
+		{30 * time.Minute, 0},
+		{time.Hour, 50},
+		{90 * time.Minute, 100},
+		{3*time.Hour + 30*time.Minute, 50},
+		{5*time.Hour + 30*time.Minute, 25},
+	}
+
+	for _, tt := range tests {
+		if got := d.At(start.Add(tt.elapsed), 2*time.Hour); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("activeDose.At(+%v) = %v, want %v", tt.elapsed, got, tt.want)
+		}
+	}
+
+	instant := activeDose{Start: start, Amount: 100}
+	if got := instant.At(start, time.Hour); got != 100 {
+		t.Errorf("activeDose.At() of an instant dose at its start = %v, want 100", got)
+	}
+}
+
+func TestActiveDrugDropsBelow(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	dose := func(after time.Duration) activeDose {
+		return activeDose{Start: start.Add(after), Amount: 100, Onset: 30 * time.Minute, Peak: 90 * time.Minute}
+	}
+
+	tests := []struct {
+		name      string
+		doses     []activeDose
+		threshold float64
+		now       time.Duration
+		want      time.Duration
+	}{
+		{"at the peak", []activeDose{dose(0)}, 25, 90 * time.Minute, 5*time.Hour + 30*time.Minute},
+		{"before the onset", []activeDose{dose(0)}, 25, 0, 5*time.Hour + 30*time.Minute},
+		{"never above", []activeDose{dose(0)}, 200, 0, 0},
+		{"already below", []activeDose{dose(0)}, 25, 6 * time.Hour, 6 * time.Hour},
+		{
+			"two doses",
+			[]activeDose{dose(0), dose(2 * time.Hour)},
+			25,
+			0,
+			3*time.Hour + 30*time.Minute + time.Duration(float64(2*time.Hour)*math.Log2(150.0/25)),
+		},
+	}
+
+	for _, tt := range tests {
+		a := activeDrug{Drug: "Test", HalfLife: 2 * time.Hour, Threshold: tt.threshold, Doses: tt.doses}
+		got := a.DropsBelow(start.Add(tt.now))
+		if diff := got.Sub(start.Add(tt.want)); diff < -time.Second || diff > time.Second {
+			t.Errorf("%s: activeDrug.DropsBelow() = %v, want %v", tt.name, got, start.Add(tt.want))
+		}
+	}
+}
+
+func TestActiveDrugPeak(t *testing.T) {
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	a := activeDrug{
+		Drug:     "Test",
+		HalfLife: 2 * time.Hour,
+		Doses: []activeDose{
+			{Start: start, Amount: 100, Onset: 30 * time.Minute, Peak: 90 * time.Minute},
+			{Start: start.Add(2 * time.Hour), Amount: 100, Onset: 30 * time.Minute, Peak: 90 * time.Minute},
+		},
+	}
+
+	tests := []struct {
+		now        time.Duration
+		wantPeak   time.Duration
+		wantAmount float64
+	}{
+		// the second dose peaks higher, as the first one hasn't been eliminated yet
+		{0, 3*time.Hour + 30*time.Minute, 150},
+		{3 * time.Hour, 3*time.Hour + 30*time.Minute, 150},
+		{5 * time.Hour, 3*time.Hour + 30*time.Minute, 150},
+	}
+
+	for _, tt := range tests {
+		peak, amount := a.Peak(start.Add(tt.now))
+		if !peak.Equal(start.Add(tt.wantPeak)) || math.Abs(amount-tt.wantAmount) > 1e-9 {
+			t.Errorf("activeDrug.Peak(+%v) = %v (%v), want %v (%v)", tt.now, peak, amount, start.Add(tt.wantPeak), tt.wantAmount)
+		}
+	}
+}
+
+func TestActiveDrugs(t *testing.T) {
+	loadTestSubstances(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	dose := func(drug string, ago time.Duration) Dose {
+		return Dose{TimeData: TimeData{Timestamp: now.Add(-ago)}, Dosage: "100mg", Drug: drug, RoA: "Oral"}
+	}
+
+	doses := []Dose{
+		dose("caffeine", 3*time.Hour),
+		dose("Caffeine", time.Hour),
+		dose("xanax", 2*time.Hour),
+		dose("Alprazolam", time.Hour),
+		dose("something new", time.Hour),
+		dose("Something New", time.Hour),
+	}
+
+	active, unknown := activeDrugs(doses, activeThreshold{Fraction: 0.05}, now)
+	if len(active) != 2 {
+		t.Fatalf("activeDrugs() returned %d drugs, want 2", len(active))
+	}
+
+	for _, a := range active {
+		if a.Drug != "Caffeine" && a.Drug != "Alprazolam" || len(a.Doses) != 2 {
+			t.Errorf("activeDrugs() returned %q with %d doses, want Caffeine or Alprazolam with 2 doses", a.Drug, len(a.Doses))
+		}
+
+		if !a.Below.Equal(a.DropsBelow(now)) {
+			t.Errorf("activeDrugs() %s below at %v, want %v", a.Drug, a.Below, a.DropsBelow(now))
+		}
+	}
+
+	if !active[0].Below.Before(active[1].Below) {
+		t.Errorf("activeDrugs() isn't sorted by when drugs drop below the threshold")
+	}
+
+	if len(unknown) != 1 || unknown[0] != "something new" {
+		t.Errorf("activeDrugs() unknown = %q, want [\"something new\"]", unknown)
+	}
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thlib/go-timezone-local/tzlocal"
+)
+
+// DoseInput is everything needed to create a new dose, usually from the -add flags
+type DoseInput struct {
+	Timezone string // if unset, the timezone timeline or most recent dose is used
+	Date     string
+	Time     string
+	End      string
+	Duration string
+	Dosage   string
+	Drug     string
+	RoA      string
+	Note     string
+	Solution string
+}
+
+func DoseInputFromFlags() DoseInput {
+	timezone := ""
+	switch options.Mode {
+	case ModeAdd, ModeAddBatch, ModeRepeat, ModeTemplate:
+		timezone = options.Timezone
+	}
+
+	return DoseInput{
+		Timezone: timezone,
+		Date:     *aDate,
+		Time:     *aTime,
+		End:      *aEnd,
+		Duration: *aDuration,
+		Dosage:   *aDosage,
+		Drug:     *aDrug,
+		RoA:      *aRoa,
+		Note:     *aNote,
+		Solution: *aSolution,
+	}
+}
+
+// newDose will create a new dose from in, positioned after every dose in doses.
+// Warnings (such as DST or the system timezone) are printed, but don't return an error.
+func newDose(doses []Dose, in DoseInput) (Dose, error) {
+	// Use the drug of the solution, unless another drug is set
+	var solution *Solution
+	if in.Solution != "" {
+		s, name, err := config.Solution(in.Solution)
+		if err != nil {
+			return Dose{}, err
+		}
+
+		if in.Drug == "" {
+			in.Drug = s.Drug
+		} else if !strings.EqualFold(in.Drug, s.Drug) {
+			return Dose{}, fmt.Errorf("solution \"%s\" is for %s, not %s!", name, s.Drug, in.Drug)
+		}
+
+		in.Solution = name
+		solution = s
+	}
+
+	// Ensure `-drug` is set
+	if in.Drug == "" {
+		return Dose{}, errors.New("`-drug` is not set!")
+	}
+
+	// Check for typos before anything else, so that no warnings are printed twice when newDose is called again with a suggestion
+	drug := canonicalDrug(doses, in.Drug)
+	if !options.Force {
+		if err := checkDrugTypo(doses, drug); err != nil {
+			return Dose{}, err
+		}
+	}
+
+	//
+	// Get timezone from the timezone timeline or the most chronologically recent dose; if `-timezone` isn't set
+	timezoneSet := in.Timezone != ""
+	if !timezoneSet {
+		if tz, err := resolveTimezone(doses, in.Date, in.Time); err != nil {
+			return Dose{}, err
+		} else {
+			in.Timezone = tz
+		}
+	}
+
+	// Get timezone locations as a time.Location now
+
+	// Used for timezone in normal timestamp / timezone
+	loc, err := time.LoadLocation(in.Timezone)
+	if err != nil {
+		return Dose{}, fmt.Errorf("failed to load location: %v", err)
+	}
+
+	// Used for timezone in created timestamp / timezone
+	locTZ, err := tzlocal.RuntimeTZ()
+	if err != nil {
+		return Dose{}, fmt.Errorf("failed to get system timezone: %v", err)
+	}
+
+	locCreated, err := time.LoadLocation(locTZ)
+	if err != nil {
+		return Dose{}, fmt.Errorf("failed to load location: %v", err)
+	}
+
+	//
+	// Parse provided `-date` and `-time` flags, using pre-defined valid layouts
+	wall, err := parseWallClock(in.Date, in.Time, loc)
+	if err != nil {
+		return Dose{}, err
+	}
+
+	// Handle times that are skipped or repeated because of DST
+	wallClock := resolveWallClock(wall, loc)
+	t := wallClock.Pick(options.Fold)
+	if warning := wallClock.Warning(options.Fold); warning != "" {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
+	if !timezoneSet {
+		warnSystemTimezone(t)
+	}
+
+	// Parse `-end` on the same date as the dose, or the next day if it's before the dose, or `-duration`
+	var duration time.Duration
+	switch {
+	case in.End != "" && in.Duration != "":
+		return Dose{}, errors.New("only one of `-end` or `-duration` can be set!")
+	case in.End != "":
+		endWall, err := parseTime(in.End, wall, wall)
+		if err != nil {
+			return Dose{}, fmt.Errorf("failed to parse `-end`: %v", err)
+		}
+
+		if !endWall.After(wall) {
+			endWall = endWall.AddDate(0, 0, 1)
+		}
+
+		duration = resolveWallClock(endWall, loc).Pick(options.Fold).Sub(t)
+	case in.Duration != "":
+		if duration, err = parseDuration(in.Duration); err != nil {
+			return Dose{}, fmt.Errorf("failed to parse `-duration`: %v", err)
+		} else if duration < 0 {
+			return Dose{}, errors.New("`-duration` can't be negative!")
+		}
+	}
+
+	// Use the usual RoA and unit of drug when they aren't set, and show which ones were used
+	defaultRoa, defaultUnit := learnDefaults(doses, drug)
+	applied := make([]string, 0)
+
+	// Replace mathematical symbols in dosage with their greek variation:
+	dosage := in.Dosage
+	dosage = strings.ReplaceAll(dosage, "µ", "μ") // U+00B5 → U+03BC
+	dosage = strings.ReplaceAll(dosage, "∆", "Δ") // U+2206 → U+0394
+
+	// Replace dosage ml with mL
+	if strings.HasSuffix(dosage, "ml") {
+		dosage = strings.TrimSuffix(dosage, "ml")
+		dosage += "mL"
+	}
+
+	// Keep the parsed dosage alongside the original string, so that stats don't have to guess.
+	// Dosages such as "?" or "a pinch" are still logged as-is, they just aren't counted in stats.
+	var quantity *Quantity
+	q, qErr := parseQuantity(dosage)
+	if dosage != "" && qErr != nil {
+		if solution != nil {
+			return Dose{}, qErr
+		}
+
+		fmt.Printf("Warning: %v, it will be logged as-is and won't be counted in stats\n", qErr)
+	}
+
+	if dosage != "" && qErr == nil {
+		if q.Unit == "" && q.Concentration == nil && defaultUnit.Value != "" {
+			dosage += defaultUnit.Value
+			if q, qErr = parseQuantity(dosage); qErr != nil {
+				return Dose{}, qErr
+			}
+
+			applied = append(applied, fmt.Sprintf("unit %s (%s)", defaultUnit.Value, defaultUnit.Source))
+		}
+
+		quantity = &q
+
+		if amount, unit := q.Total(); solution != nil && q.Concentration == nil {
+			if _, _, ok := solution.Convert(amount, unit); !ok {
+				return Dose{}, fmt.Errorf("can't convert %s of solution \"%s\" (%s), expected a volume such as 1.5mL", dosage, in.Solution, solution.Concentration)
+			}
+		}
+	}
+
+	roa := in.RoA
+	if roa == "" {
+		roa = defaultRoa.Value
+		applied = append(applied, fmt.Sprintf("RoA %s (%s)", defaultRoa.Value, defaultRoa.Source))
+	} else if name, err := validateRoa(roa); err == nil {
+		roa = name
+	} else if options.Force {
+		roa = caseFmt(roa)
+	} else {
+		return Dose{}, err
+	}
+
+	if len(applied) > 0 {
+		fmt.Printf("Using defaults for %s: %s\n", drug, strings.Join(applied, ", "))
+	}
+
+	pos, _ := lastPosition(doses)
+	return Dose{
+		Position: pos + 1,
+		Created: &TimeData{
+			Timestamp: time.Now().In(locCreated),
+			Timezone:  locTZ,
+		},
+		TimeData: TimeData{
+			Timestamp: t,
+			Timezone:  in.Timezone,
+		},
+		Date:     t.Format("2006/01/02"),
+		Time:     formatClock(t),
+		Duration: formatDuration(duration),
+		Dosage:   dosage,
+		Quantity: quantity,
+		Solution: in.Solution,
+		Drug:     drug,
+		RoA:      roa,
+		Note:     in.Note,
+	}, nil
+}
+
+// canonicalDrug returns the name of drug from the substance database, or the casing it was last logged with.
+// Names and aliases are replaced, eg Xanax → Alprazolam, while misspellings are suggested by checkDrugTypo instead.
+// caseFmt is only used for drugs that have never been logged before.
+func canonicalDrug(doses []Dose, drug string) string {
+	if substance := config.substances.Get(drug); substance != nil && !substance.IsMisspelling(drug) {
+		return substance.Name
+	}
+
+	for i := len(doses) - 1; i >= 0; i-- {
+		if strings.EqualFold(doses[i].Drug, drug) {
+			return doses[i].Drug
+		}
+	}
+
+	return caseFmt(drug)
+}
+
+// DrugTypoError is returned by newDose when a drug has never been logged, but is close to a drug that is known
+type DrugTypoError struct {
+	Drug       string
+	Suggestion string
+}
+
+func (e *DrugTypoError) Error() string {
+	return fmt.Sprintf("\"%s\" has never been logged, did you mean \"%s\"? Use -force to add it anyway", e.Drug, e.Suggestion)
+}
+
+// checkDrugTypo returns a DrugTypoError if drug is new, and within a few edits of a drug in the log or substance database.
+// A new misspelling of a known substance suggests its name, names and aliases are replaced by canonicalDrug instead.
+func checkDrugTypo(doses []Dose, drug string) error {
+	substance := config.substances.Get(drug)
+	if substance != nil && !substance.IsMisspelling(drug) {
+		return nil
+	}
+
+	known := make(map[string]bool)
+	for _, d := range doses {
+		if strings.EqualFold(d.Drug, drug) {
+			return nil
+		}
+
+		known[d.Drug] = true
+	}
+
+	if substance != nil {
+		return &DrugTypoError{Drug: drug, Suggestion: substance.Name}
+	}
+
+	for _, name := range config.substances.Names() {
+		known[name] = true
+	}
+
+	names := make([]string, 0)
+	for name := range known {
+		names = append(names, name)
+	}
+
+	// Allow more edits for longer names, as short names such as 2C-B and 2C-C are often only 1 edit apart
+	maxDistance := len([]rune(drug)) / 4
+	if maxDistance < 1 {
+		maxDistance = 1
+	} else if maxDistance > 3 {
+		maxDistance = 3
+	}
+
+	sort.Strings(names)
+	if suggestion := closestName(drug, names, maxDistance); suggestion != "" {
+		return &DrugTypoError{Drug: drug, Suggestion: suggestion}
+	}
+
+	return nil
+}
+
+// addDoses will add new doses to doses, and re-sort by chronological date and time to handle adding a dose in the past
+func addDoses(doses []Dose, added ...Dose) []Dose {
+	for _, d := range added {
+		doses = append(doses, d)
+		options.LastAddedPos = d.Position
+	}
+
+	// Keep doses with the same timestamp (eg from a template) in the order they were added
+	sort.SliceStable(doses, func(i, j int) bool {
+		return doses[i].Timestamp.Unix() < doses[j].Timestamp.Unix()
+	})
+
+	return doses
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// readBatch will read one dose per line from r, either in the one-line format used by -add or as CSV:
+//
+//	date,time,dosage,drug,roa,note
+//
+// Empty lines, lines starting with "#" and a "date,time,..." header are skipped.
+// Fields that aren't set by a line fall back to flags such as -timezone or -roa, and fields set by a line take priority.
+// Unlike a one-line entry for -add, a line that conflicts with a flag isn't an error, so flags can be used as defaults.
+func readBatch(r io.Reader) ([]DoseInput, error) {
+	inputs := make([]DoseInput, 0)
+	problems := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		in, err := parseBatchLine(line)
+		if err == errBatchHeader {
+			continue
+		} else if err != nil {
+			problems = append(problems, fmt.Sprintf("line %v: %v", n, err))
+			continue
+		}
+
+		inputs = append(inputs, in)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return inputs, fmt.Errorf("failed to read batch: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return inputs, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return inputs, nil
+}
+
+var errBatchHeader = errors.New("csv header")
+
+// parseBatchLine will parse line as CSV if it looks like "date,time,dosage,drug,...", otherwise as a one-line entry.
+// A CSV date never contains a space, so a one-line entry with commas in the note isn't mistaken for CSV.
+func parseBatchLine(line string) (DoseInput, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	record, err := reader.Read()
+	if err != nil || len(record) < 4 || strings.Contains(record[0], " ") {
+		row, err := parseOneLine([]string{line})
+		if err != nil {
+			return row, err
+		}
+
+		in := DoseInputFromFlags()
+		in.override(row)
+		return in, nil
+	}
+
+	if strings.EqualFold(record[0], "date") {
+		return DoseInput{}, errBatchHeader
+	}
+
+	if len(record) > 6 {
+		return DoseInput{}, fmt.Errorf("expected at most 6 CSV fields (date,time,dosage,drug,roa,note), got %v", len(record))
+	}
+
+	for len(record) < 6 {
+		record = append(record, "")
+	}
+
+	in := DoseInputFromFlags()
+	in.override(DoseInput{Date: record[0], Time: record[1], Dosage: record[2], Drug: record[3], RoA: record[4], Note: record[5]})
+	return in, nil
+}
+
+// override sets every field of in that is set in row, so that a line of a batch takes priority over flags
+func (in *DoseInput) override(row DoseInput) {
+	fields := []struct {
+		field *string
+		value string
+	}{
+		{&in.Date, row.Date},
+		{&in.Time, row.Time},
+		{&in.Dosage, row.Dosage},
+		{&in.Drug, row.Drug},
+		{&in.RoA, row.RoA},
+		{&in.Note, row.Note},
+	}
+
+	for _, f := range fields {
+		if value := strings.TrimSpace(f.value); value != "" {
+			*f.field = value
+		}
+	}
+}
+
+// newDoses will create a dose for every input, with sequential positions after doses.
+// Every input is validated before returning, so that a batch is either added in full or not at all.
+func newDoses(doses []Dose, inputs []DoseInput) ([]Dose, error) {
+	added := make([]Dose, 0)
+	problems := make([]string, 0)
+
+	// Copy doses so that positions and timezones are resolved with the earlier doses in the batch
+	all := append(make([]Dose, 0, len(doses)+len(inputs)), doses...)
+
+	for n, in := range inputs {
+		dose, err := newDose(all, in)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("dose %v (%s): %v", n+1, in.Drug, err))
+			continue
+		}
+
+		added = append(added, dose)
+		all = append(all, dose)
+	}
+
+	if len(problems) > 0 {
+		return added, errors.New(strings.Join(problems, "\n"))
+	}
+
+	return added, nil
+}
+
+// openBatch returns stdin, or the file at path if it's set
+func openBatch(path string) (io.ReadCloser, error) {
+	if path == "" || path == "-" {
+		return os.Stdin, nil
+	}
+
+	return os.Open(path)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadBatch(t *testing.T) {
+	roa, note := *aRoa, *aNote
+	*aRoa, *aNote = "Oral", "from flags"
+	defer func() { *aRoa, *aNote = roa, note }()
+
+	batch := strings.Join([]string{
+		"# comment",
+		"date,time,dosage,drug,roa,note",
+		"20mg caffeine",
+		`20mg caffeine insufflated "with coffee"`,
+		"2024-03-01,10:00,20mg,Caffeine,Insufflated,",
+		"2024-03-01,10:00,20mg,Caffeine,,with coffee",
+		"",
+	}, "\n")
+
+	want := []DoseInput{
+		{Dosage: "20mg", Drug: "caffeine", RoA: "Oral", Note: "from flags"},
+		{Dosage: "20mg", Drug: "caffeine", RoA: "Insufflated", Note: "with coffee"},
+		{Date: "2024-03-01", Time: "10:00", Dosage: "20mg", Drug: "Caffeine", RoA: "Insufflated", Note: "from flags"},
+		{Date: "2024-03-01", Time: "10:00", Dosage: "20mg", Drug: "Caffeine", RoA: "Oral", Note: "with coffee"},
+	}
+
+	got, err := readBatch(strings.NewReader(batch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("readBatch() returned %v inputs, want %v", len(got), len(want))
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("readBatch() line %v = %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+}
+
+func TestReadBatchErrors(t *testing.T) {
+	tests := []string{
+		"20mg",
+		"20mg caffeine -y",
+		"2024-03-01,10:00,20mg,Caffeine,Oral,note,extra",
+	}
+
+	for _, line := range tests {
+		if _, err := readBatch(strings.NewReader(line)); err == nil {
+			t.Errorf("readBatch(%q) error = nil, want an error", line)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// CategoryDefault is used for any dose that doesn't match a loaded category file
+const CategoryDefault = "recreational"
+
+var (
+	categories       Categories
+	categoryTagRegex = regexp.MustCompile(`#([A-Za-z][A-Za-z0-9_-]*)`)
+)
+
+// Category is a named list of patterns, loaded from a file in the same format as therapeutic.txt.
+// Each line is a regex which is matched against a "date,drug,note" line, so `rg -f therapeutic.txt` will keep working.
+type Category struct {
+	Name     string
+	Patterns []*regexp.Regexp
+}
+
+type Categories []Category
+
+// loadCategories will load a comma separated list of category files, relative paths are next to base (the -config URL or path).
+// The name of each category is the file name without the extension, eg therapeutic.txt → therapeutic.
+func loadCategories(paths, base string) (Categories, error) {
+	c := make(Categories, 0)
+
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+
+		path = pathNextTo(base, path)
+		b, err := readFileOrUrl(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to load category file \"%s\": %v", path, err)
+		}
+
+		category := Category{Name: strings.ToLower(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))}
+
+		for n, line := range strings.Split(string(b), "\n") {
+			if line = strings.TrimSpace(line); line == "" {
+				continue
+			}
+
+			r, err := regexp.Compile(line)
+			if err != nil {
+				return c, fmt.Errorf("failed to compile line %v of \"%s\": %v", n+1, path, err)
+			}
+
+			category.Patterns = append(category.Patterns, r)
+		}
+
+		c = append(c, category)
+	}
+
+	return c, nil
+}
+
+// Get returns the category of d.
+// A "#category" tag in the note always takes priority, otherwise the first matching category file is used.
+func (c Categories) Get(d Dose) string {
+	for _, tag := range categoryTagRegex.FindAllStringSubmatch(d.Note, -1) {
+		if name := strings.ToLower(tag[1]); c.Known(name) {
+			return name
+		}
+	}
+
+	line := d.Date + "," + d.Drug + "," + d.Note
+	for _, category := range c {
+		for _, r := range category.Patterns {
+			if r.MatchString(line) {
+				return category.Name
+			}
+		}
+	}
+
+	return CategoryDefault
+}
+
+// Known returns true if name is CategoryDefault or the name of a loaded category
+func (c Categories) Known(name string) bool {
+	if name == CategoryDefault {
+		return true
+	}
+
+	for _, category := range c {
+		if category.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Names returns the names of all loaded categories, including CategoryDefault
+func (c Categories) Names() []string {
+	names := make([]string, 0)
+	for _, category := range c {
+		names = append(names, category.Name)
+	}
+
+	return append(names, CategoryDefault)
+}
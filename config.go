@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	config           = &Config{}
+	classPresetRegex = regexp.MustCompile(`(^|[|(])@([A-Za-z0-9_-]+)`)
+)
+
+// Config is loaded from -config, which defaults to doses-config.json next to doses.json.
+// A missing config file is not an error, every field is optional.
+type Config struct {
+	Classes    map[string]string         `json:"classes,omitempty"`    // named filter presets, eg "stim": "(am(ph|f)etamine|...)"
+	Templates  map[string][]TemplateDose `json:"templates,omitempty"`  // named groups of doses for -template, eg "morning-meds"
+	Solutions  map[string]*Solution      `json:"solutions,omitempty"`  // named solutions for -solution, eg "pregabalin-50"
+	Units      map[string]string         `json:"units,omitempty"`      // preferred unit for each drug, eg "Caffeine": "mg"
+	Substances []Substance               `json:"substances,omitempty"` // added to the bundled substances.json
+	Defaults   map[string]DrugDefaults   `json:"defaults,omitempty"`   // override the RoA and unit learned from the log
+	RoAs       map[string][]string       `json:"roas,omitempty"`       // more RoAs and their aliases, eg "Vaginal": ["PV"]
+	Redose     map[string]string         `json:"redose,omitempty"`     // minimum time between doses of a drug or class, eg "Caffeine": "4h"
+	Limits     []Limit                   `json:"limits,omitempty"`     // caps on a drug or class in a rolling window, checked when adding
+	Combos     []Interaction             `json:"combos,omitempty"`     // interactions, added to the bundled interactions.json
+	Tolerance  map[string]ToleranceCurve `json:"tolerance,omitempty"`  // how tolerance builds and decays for a drug or class
+
+	classRegex map[string]*regexp.Regexp // generated from Classes
+	substances Substances                // generated from substances.json and Substances
+	redose     map[string]time.Duration  // generated from Redose
+	combos     Interactions              // generated from interactions.json and Combos
+	tolerance  map[string]ToleranceCurve // generated from toleranceDefaults and Tolerance
+}
+
+func loadConfig(path string) (*Config, error) {
+	c := &Config{}
+
+	b, err := readFileOrUrl(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return c, c.compile()
+		}
+
+		return c, err
+	}
+
+	if err := json.Unmarshal(b, c); err != nil {
+		return c, fmt.Errorf("failed to unmarshal config \"%s\": %v", path, err)
+	}
+
+	return c, c.compile()
+}
+
+func (c *Config) compile() error {
+	c.classRegex = make(map[string]*regexp.Regexp)
+
+	for name, class := range c.Classes {
+		r, err := regexp.Compile(fmt.Sprintf("(?i)%s", class))
+		if err != nil {
+			return fmt.Errorf("failed to compile class \"%s\": %v", name, err)
+		}
+
+		c.classRegex[strings.ToLower(name)] = r
+	}
+
+	for name, s := range c.Solutions {
+		concentration, err := parseConcentration(s.Concentration)
+		if err != nil {
+			return fmt.Errorf("failed to parse solution \"%s\": %v", name, err)
+		}
+
+		s.concentration = concentration
+	}
+
+	c.redose = make(map[string]time.Duration)
+	for name, interval := range c.Redose {
+		d, err := parseDuration(interval)
+		if err != nil {
+			return fmt.Errorf("failed to parse redose interval of \"%s\": %v", name, err)
+		}
+
+		c.redose[name] = d
+	}
+
+	substances, err := loadSubstances(c.Substances)
+	if err != nil {
+		return err
+	}
+
+	c.substances = substances
+
+	// Limits are compiled last, as classes from the substance database can be used
+	for i := range c.Limits {
+		if err := c.Limits[i].compile(c.ClassNames()); err != nil {
+			return err
+		}
+	}
+
+	interactions, err := loadInteractions(c.Combos, c.substances, c.ClassNames())
+	if err != nil {
+		return err
+	}
+
+	c.combos = interactions
+
+	c.tolerance = make(map[string]ToleranceCurve)
+	for _, curves := range []map[string]ToleranceCurve{toleranceDefaults, c.Tolerance} {
+		for name, curve := range curves {
+			if err := curve.compile(name); err != nil {
+				return err
+			}
+
+			// The config replaces a default with a different case, eg "Psychedelic"
+			for existing := range c.tolerance {
+				if strings.EqualFold(existing, name) {
+					delete(c.tolerance, existing)
+				}
+			}
+
+			c.tolerance[name] = curve
+		}
+	}
+
+	return nil
+}
+
+// ClassNames returns the names of every class, including classes from the substance database, sorted alphabetically
+func (c *Config) ClassNames() []string {
+	known := make(map[string]bool)
+	for name := range c.classRegex {
+		known[name] = true
+	}
+
+	for _, substance := range c.substances {
+		for _, class := range substance.Classes {
+			known[strings.ToLower(class)] = true
+		}
+	}
+
+	names := make([]string, 0)
+	for name := range known {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ClassMatches returns true if dose is in the class name, either from the substance database or the config.
+// Classes from the config are matched the same way as -g, so the regexes from .env can be used as-is.
+func (c *Config) ClassMatches(name string, dose Dose, options *DisplayOptions) bool {
+	if substance := c.substances.Get(dose.Drug); substance != nil && substance.InClass(name) {
+		return true
+	}
+
+	r, ok := c.classRegex[strings.ToLower(name)]
+	return ok && r.MatchString(dose.FilterString(options))
+}
+
+// ExpandPresets replaces "@name" in a -g filter with the regex of the class name.
+// Classes from the substance database are replaced with their substance names, the same way as ClassMatches.
+// Only "@name" at the start of the filter or after "|" or "(" is replaced, so "@" can still be matched as-is,
+// and "\@name" is never replaced.
+func (c *Config) ExpandPresets(filter string) (string, error) {
+	var err error
+
+	expanded := classPresetRegex.ReplaceAllStringFunc(filter, func(s string) string {
+		match := classPresetRegex.FindStringSubmatch(s)
+		name := strings.ToLower(match[2])
+
+		regexes := make([]string, 0)
+		if r, ok := c.classRegex[name]; ok {
+			regexes = append(regexes, r.String())
+		}
+
+		if r := c.substances.ClassRegex(name); r != "" {
+			regexes = append(regexes, r)
+		}
+
+		if len(regexes) == 0 {
+			err = fmt.Errorf("unknown class \"%s\", known classes: %s", name, strings.Join(c.ClassNames(), ", "))
+			return s
+		}
+
+		return fmt.Sprintf("%s(?:%s)", match[1], strings.Join(regexes, "|"))
+	})
+
+	return expanded, err
+}
+
+// ClassFilter is a union of intersections of classes, eg "stim+amph,opiate" is (stim AND amph) OR opiate
+type ClassFilter [][]string
+
+func ParseClassFilter(s string) ClassFilter {
+	f := make(ClassFilter, 0)
+
+	for _, union := range strings.Split(s, ",") {
+		intersection := make([]string, 0)
+
+		for _, name := range strings.Split(union, "+") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				intersection = append(intersection, name)
+			}
+		}
+
+		if len(intersection) > 0 {
+			f = append(f, intersection)
+		}
+	}
+
+	return f
+}
+
+// Validate returns an error if any class in f isn't defined in c
+func (f ClassFilter) Validate(c *Config) error {
+	for _, intersection := range f {
+		for _, name := range intersection {
+			if _, ok := c.classRegex[name]; !ok && !c.substances.HasClass(name) {
+				return fmt.Errorf("unknown class \"%s\", known classes: %s", name, strings.Join(c.ClassNames(), ", "))
+			}
+		}
+	}
+
+	return nil
+}
+
+func (f ClassFilter) Matches(dose Dose, options *DisplayOptions) bool {
+	for _, intersection := range f {
+		matched := true
+
+		for _, name := range intersection {
+			if !config.ClassMatches(name, dose, options) {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedoseInterval returns the minimum time between doses of the drug of dose, or false if there isn't one.
+// An interval for the drug takes priority, otherwise the longest interval of any of its classes is used.
+func (c *Config) RedoseInterval(dose Dose) (time.Duration, bool) {
+	interval, ok := time.Duration(0), false
+	for name, d := range c.redose {
+		if sameDrug(name, dose.Drug) {
+			return d, true
+		}
+
+		if c.ClassMatches(name, dose, options) && d > interval {
+			interval, ok = d, true
+		}
+	}
+
+	return interval, ok
+}
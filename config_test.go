@@ -0,0 +1,52 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestExpandPresets(t *testing.T) {
+	c := &Config{Classes: map[string]string{"stim": "caffeine|nicotine", "work": "#work"}}
+	if err := c.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		filter  string
+		match   []string
+		noMatch []string
+		wantErr bool
+	}{
+		{"@work", []string{"20mg Caffeine, Oral, Note: #work"}, []string{"20mg Caffeine, Oral"}, false},
+		{"@stim", []string{"20mg Caffeine, Oral", "10mg Amphetamine, Oral"}, []string{"1mg Alprazolam, Oral"}, false},
+		{"@benzo", []string{"1mg Alprazolam, Oral", "1mg Xanax, Oral"}, []string{"20mg Caffeine, Oral"}, false},
+		{"Oral|@benzo", []string{"1mg Alprazolam, Sublingual", "20mg Caffeine, Oral"}, []string{"20mg Caffeine, Insufflated"}, false},
+		{"(@work)", []string{"Note: #work"}, []string{"Note: work"}, false},
+		{"user@work", []string{"user@work"}, []string{"#work"}, false},
+		{`\@work`, []string{"@work"}, []string{"#work"}, false},
+		{"@nope", nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		expanded, err := c.ExpandPresets(tt.filter)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ExpandPresets(%q) error = %v, wantErr %v", tt.filter, err, tt.wantErr)
+			continue
+		} else if err != nil {
+			continue
+		}
+
+		r := regexp.MustCompile("(?i)" + expanded)
+		for _, s := range tt.match {
+			if !r.MatchString(s) {
+				t.Errorf("ExpandPresets(%q) = %q, doesn't match %q", tt.filter, expanded, s)
+			}
+		}
+
+		for _, s := range tt.noMatch {
+			if r.MatchString(s) {
+				t.Errorf("ExpandPresets(%q) = %q, matches %q", tt.filter, expanded, s)
+			}
+		}
+	}
+}
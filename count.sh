@@ -31,7 +31,7 @@ y_num() {
 
 create-dates-year() {
     if [[ -z "$2" ]]; then
-        ./doses-logger -n -1 -j | jq -r '.[] | .date + "," + .drug + "," + .note' | rg -f therapeutic.txt -v | rg -f count-filter.txt -v | cut -d "," -f 1 | grep -E "^$1" | sort | uniq -c | awk '{printf "%s,%s\n", $2,$1}' > dates.txt
+        ./doses-logger -n -1 -j -category recreational | jq -r '.[] | .date + "," + .drug + "," + .note' | rg -f count-filter.txt -v | cut -d "," -f 1 | grep -E "^$1" | sort | uniq -c | awk '{printf "%s,%s\n", $2,$1}' > dates.txt
     else
         ./doses-logger -n -1 -j -g "$1.*$2" -j | jq -r '.[] | .date + "," + .dosage' | awk '{split($0,a,","); t[a[1]] += a[2];} END { for (key in t) { printf "%s,%s\n", key, t[key]; } }' | sort > dates.txt
     fi
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoADefault is used when a drug has never been logged with a RoA, and has no default in the config
+const RoADefault = "Oral"
+
+// DrugDefaults overrides the RoA and unit that are learned from the log for a drug, eg "Nicotine": {"roa": "Buccal"}
+type DrugDefaults struct {
+	RoA  string `json:"roa,omitempty"`
+	Unit string `json:"unit,omitempty"`
+}
+
+// DrugDefault is a default RoA or unit for a drug, and where it came from
+type DrugDefault struct {
+	Value  string
+	Source string // eg "config" or "9 of 12 doses"
+}
+
+// DrugDefaults returns the defaults for drug from the config, if any
+func (c *Config) DrugDefaults(drug string) DrugDefaults {
+	for name, defaults := range c.Defaults {
+		if sameDrug(name, drug) {
+			return defaults
+		}
+	}
+
+	return DrugDefaults{}
+}
+
+// learnDefaults returns the default RoA and unit of drug.
+// The config takes priority, otherwise the most common RoA and unit that drug was logged with is used.
+func learnDefaults(doses []Dose, drug string) (roa DrugDefault, unit DrugDefault) {
+	roas, units := newCounter(), newCounter()
+
+	for _, d := range doses {
+		if !strings.EqualFold(d.Drug, drug) {
+			continue
+		}
+
+		name, _ := normalizeRoa(d.RoA)
+		roas.Add(name)
+		if quantity, err := d.ParsedQuantity(); err == nil {
+			units.Add(quantity.Unit)
+		}
+	}
+
+	roa, unit = roas.MostCommon(), units.MostCommon()
+	if roa.Value == "" {
+		roa = DrugDefault{Value: RoADefault, Source: "fallback"}
+	}
+
+	defaults := config.DrugDefaults(drug)
+	if defaults.RoA != "" {
+		name, _ := normalizeRoa(defaults.RoA)
+		roa = DrugDefault{Value: name, Source: "config"}
+	}
+
+	if defaults.Unit != "" {
+		unit = DrugDefault{Value: defaults.Unit, Source: "config"}
+	}
+
+	return roa, unit
+}
+
+// counter counts how often each value is used, so that the most common one can be picked
+type counter struct {
+	counts map[string]int
+	last   map[string]int // the last time each value was added, to break ties with the most recent value
+	total  int
+}
+
+func newCounter() *counter {
+	return &counter{counts: make(map[string]int), last: make(map[string]int)}
+}
+
+// Add counts value, empty values are ignored
+func (c *counter) Add(value string) {
+	if value == "" {
+		return
+	}
+
+	c.total++
+	c.counts[value]++
+	c.last[value] = c.total
+}
+
+// MostCommon returns the value that was added the most, or the most recent one if it's a tie
+func (c *counter) MostCommon() DrugDefault {
+	best := ""
+	for value, count := range c.counts {
+		if best == "" || count > c.counts[best] || count == c.counts[best] && c.last[value] > c.last[best] {
+			best = value
+		}
+	}
+
+	if best == "" {
+		return DrugDefault{}
+	}
+
+	return DrugDefault{Value: best, Source: fmt.Sprintf("%v of %v doses", c.counts[best], c.total)}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed interactions.json
+var interactionsBundled []byte
+
+// interactionWindowDefault is how long a dose counts towards interactions when its drug has no duration in the substance database
+const interactionWindowDefault = 6 * time.Hour
+
+// Risk is how risky an Interaction is, in the same levels as the TripSit combo chart
+type Risk int64
+
+const (
+	RiskUnknown Risk = iota
+	RiskLow
+	RiskCaution
+	RiskUnsafe
+	RiskDangerous
+)
+
+func ParseRisk(s string) (Risk, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "low":
+		return RiskLow, nil
+	case "caution":
+		return RiskCaution, nil
+	case "unsafe":
+		return RiskUnsafe, nil
+	case "dangerous":
+		return RiskDangerous, nil
+	default:
+		return RiskUnknown, fmt.Errorf("\"%s\" is not a valid risk, must be \"low\", \"caution\", \"unsafe\" or \"dangerous\"", s)
+	}
+}
+
+func (r Risk) String() string {
+	switch r {
+	case RiskLow:
+		return "Low risk"
+	case RiskCaution:
+		return "Caution"
+	case RiskUnsafe:
+		return "Unsafe"
+	case RiskDangerous:
+		return "Dangerous"
+	default:
+		return "Unknown"
+	}
+}
+
+// Interaction is the risk of combining two drugs or classes, A and B can each be a substance or a class.
+// Interactions are bundled in interactions.json and extended by the config, where the same pair replaces a bundled one.
+type Interaction struct {
+	A    string `json:"a"`
+	B    string `json:"b"`
+	Risk string `json:"risk"` // "low", "caution", "unsafe" or "dangerous"
+	Note string `json:"note,omitempty"`
+
+	risk        Risk // generated from Risk
+	specificity int  // how many of A and B are substances rather than classes, more specific interactions take priority
+}
+
+// Interactions is every known interaction
+type Interactions []Interaction
+
+// loadInteractions will load the bundled interactions, and then extended.
+// Each drug or class in an interaction must be in substances or classes.
+func loadInteractions(extended []Interaction, substances Substances, classes []string) (Interactions, error) {
+	bundled := make([]Interaction, 0)
+	if err := json.Unmarshal(interactionsBundled, &bundled); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bundled interactions: %v", err)
+	}
+
+	interactions := make(Interactions, 0)
+	for _, i := range append(bundled, extended...) {
+		risk, err := ParseRisk(i.Risk)
+		if err != nil {
+			return nil, fmt.Errorf("interaction of %s and %s: %v", i.A, i.B, err)
+		}
+
+		i.risk = risk
+		for _, name := range []string{i.A, i.B} {
+			switch {
+			case substances.Get(name) != nil:
+				i.specificity++
+			case !containsFold(classes, name):
+				return nil, fmt.Errorf("interaction of %s and %s: \"%s\" is not a known substance or class", i.A, i.B, name)
+			}
+		}
+
+		// Replace the bundled interaction of the same pair
+		replaced := false
+		for n, existing := range interactions {
+			if existing.Is(i.A, i.B) {
+				interactions[n], replaced = i, true
+			}
+		}
+
+		if !replaced {
+			interactions = append(interactions, i)
+		}
+	}
+
+	return interactions, nil
+}
+
+// Is returns true if i is the interaction of a and b, in either order
+func (i Interaction) Is(a, b string) bool {
+	return (strings.EqualFold(i.A, a) && strings.EqualFold(i.B, b)) || (strings.EqualFold(i.A, b) && strings.EqualFold(i.B, a))
+}
+
+// Matches returns true if i applies to the combination of x and y, which must be different drugs
+func (i Interaction) Matches(x, y Dose) bool {
+	return (interactionMatches(i.A, x) && interactionMatches(i.B, y)) || (interactionMatches(i.A, y) && interactionMatches(i.B, x))
+}
+
+// interactionMatches returns true if name is the drug of dose, or one of its classes
+func interactionMatches(name string, dose Dose) bool {
+	if config.substances.Get(name) != nil {
+		return sameDrug(name, dose.Drug)
+	}
+
+	return config.ClassMatches(name, dose, options)
+}
+
+// Get returns the most specific interaction of x and y, and the riskiest one if there is more than one.
+// Returns false if the drugs are the same, including an alias and its canonical name, or no interaction is known.
+func (is Interactions) Get(x, y Dose) (Interaction, bool) {
+	if sameDrug(x.Drug, y.Drug) {
+		return Interaction{}, false
+	}
+
+	found, ok := Interaction{}, false
+	for _, i := range is {
+		if !i.Matches(x, y) {
+			continue
+		}
+
+		if !ok || i.specificity > found.specificity || (i.specificity == found.specificity && i.risk > found.risk) {
+			found, ok = i, true
+		}
+	}
+
+	return found, ok
+}
+
+// interactionWindow returns when the effects of d start and end, for checking which doses overlap
+func interactionWindow(d Dose) (time.Time, time.Time) {
+	window := interactionWindowDefault
+	if substance := config.substances.Get(d.Drug); substance != nil && substance.duration != 0 {
+		window = substance.duration
+	}
+
+	return d.Timestamp, d.End().Add(window)
+}
+
+// DoseInteraction is an interaction between two doses that overlap
+type DoseInteraction struct {
+	Interaction
+	Dose  Dose
+	Other Dose
+}
+
+// String formats the interaction, eg "Dangerous: Alcohol + Ketamine (taken 18:07), Alcohol and disso: Both cause ..."
+func (di DoseInteraction) String(now time.Time) string {
+	s := fmt.Sprintf("%s: %s + %s", di.risk, di.Dose.Drug, di.Other.Drug)
+
+	// Drugs given to -interactions haven't been taken, so they don't have a time
+	if di.Other.Position >= 0 {
+		s += fmt.Sprintf(" (taken %s)", formatNearTime(di.Other.Timestamp, now))
+	}
+
+	// Show which rule matched when it's for a class, as it isn't obvious why the drugs interact
+	if di.specificity < 2 {
+		s += fmt.Sprintf(", %s and %s", di.A, di.B)
+	}
+
+	if di.Note != "" {
+		s += ": " + di.Note
+	}
+
+	return s
+}
+
+// findInteractions returns the interactions of each dose in checked with every other dose that it overlaps with.
+// The riskiest interactions are returned first, and each pair of drugs is only returned once.
+func findInteractions(doses []Dose, checked ...Dose) []DoseInteraction {
+	found := make([]DoseInteraction, 0)
+	seen := make(map[string]bool)
+
+	for _, x := range checked {
+		xStart, xEnd := interactionWindow(x)
+
+		for _, y := range doses {
+			yStart, yEnd := interactionWindow(y)
+			if x.Position == y.Position || xStart.After(yEnd) || yStart.After(xEnd) {
+				continue
+			}
+
+			// Use the canonical names, so that an alias and its canonical name are the same pair
+			xKey, yKey := drugKey(x.Drug), drugKey(y.Drug)
+			key := xKey + "\n" + yKey
+			if yKey < xKey {
+				key = yKey + "\n" + xKey
+			}
+
+			if seen[key] {
+				continue
+			}
+
+			if i, ok := config.combos.Get(x, y); ok {
+				found = append(found, DoseInteraction{Interaction: i, Dose: x, Other: y})
+				seen[key] = true
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].risk > found[j].risk
+	})
+
+	return found
+}
+
+// printInteractions prints every interaction of the added doses, riskier interactions are printed as a warning
+func printInteractions(doses []Dose, added ...Dose) {
+	now := currentTime(doses)
+
+	for _, di := range findInteractions(doses, added...) {
+		if di.risk >= RiskUnsafe {
+			fmt.Printf("WARNING: %s\n", di.String(now))
+		} else {
+			fmt.Printf("%s\n", di.String(now))
+		}
+	}
+}
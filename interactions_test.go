@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFindInteractions(t *testing.T) {
+	c := &Config{}
+	if err := c.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config
+	config = c
+	t.Cleanup(func() { config = previous })
+
+	start := time.Date(2024, 3, 1, 20, 0, 0, 0, time.UTC)
+	dose := func(position int, drug string, after time.Duration) Dose {
+		return Dose{Position: position, TimeData: TimeData{Timestamp: start.Add(after)}, Drug: drug}
+	}
+
+	alprazolam, xanax, alcohol := dose(0, "Alprazolam", 0), dose(1, "Xanax", time.Hour), dose(2, "Alcohol", 2*time.Hour)
+	caffeine := dose(3, "Caffeine", 7*24*time.Hour)
+	doses := []Dose{alprazolam, xanax, alcohol, caffeine}
+
+	if _, ok := config.combos.Get(alprazolam, xanax); ok {
+		t.Errorf("Interactions.Get(Alprazolam, Xanax) found an interaction of a drug with its alias")
+	}
+
+	tests := []struct {
+		name    string
+		checked []Dose
+		want    int
+	}{
+		{"alias", []Dose{xanax}, 1},
+		{"canonical name and alias", []Dose{alprazolam, xanax}, 1},
+		{"every dose", doses, 1},
+		{"no overlap", []Dose{caffeine}, 0},
+	}
+
+	for _, tt := range tests {
+		found := findInteractions(doses, tt.checked...)
+		if len(found) != tt.want {
+			t.Errorf("%s: findInteractions() found %v interactions, want %v: %+v", tt.name, len(found), tt.want, found)
+		}
+
+		for _, di := range found {
+			if sameDrug(di.Dose.Drug, di.Other.Drug) {
+				t.Errorf("%s: findInteractions() found an interaction of %s with %s", tt.name, di.Dose.Drug, di.Other.Drug)
+			}
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lastDose is a row of -last, the last dose of a drug and how much of it was taken in the day before now
+type lastDose struct {
+	Dose
+	Unit      Unit    // the unit of the last dose, that DayAmount is in
+	DayAmount float64 // 0 if none of the doses in the last day could be converted to Unit
+	DayDoses  int
+}
+
+// lastDoses returns the last dose of each drug in doses, with the drug that was taken most recently at the bottom
+func lastDoses(doses []Dose, now time.Time) []lastDose {
+	last := make(map[string]*lastDose)
+	for _, d := range doses {
+		if l, ok := last[d.Drug]; !ok || d.Timestamp.After(l.Timestamp) {
+			last[d.Drug] = &lastDose{Dose: d}
+		}
+	}
+
+	for _, l := range last {
+		if _, unit, err := l.Total(); err == nil {
+			l.Unit = lookupUnit(unit)
+		}
+	}
+
+	for _, d := range doses {
+		l := last[d.Drug]
+		if d.Timestamp.After(now) || now.Sub(d.Timestamp) > 24*time.Hour {
+			continue
+		}
+
+		l.DayDoses++
+		if amount, ok := d.AmountIn(l.Unit); ok && l.Unit.Name != "" {
+			l.DayAmount += amount
+		}
+	}
+
+	sorted := make([]lastDose, 0)
+	for _, l := range last {
+		sorted = append(sorted, *l)
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Timestamp.Equal(sorted[j].Timestamp) {
+			return sorted[i].Drug < sorted[j].Drug
+		}
+
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	return sorted
+}
+
+// Format returns a summary of l, eg "Caffeine: 100mg at 17:17 (1h ago), 300mg in the last 24h (2 doses), OK to redose at 21:17"
+func (l lastDose) Format(now time.Time) string {
+	last := l.Drug + ":"
+	if l.Dosage != "" {
+		last += " " + l.DisplayDosage(options)
+	}
+
+	ago := "just now"
+	if d := now.Sub(l.Timestamp).Round(time.Minute); d > 0 {
+		ago = formatDurationDays(d) + " ago"
+	} else if d < 0 {
+		ago = "in " + formatDurationDays(-d)
+	}
+
+	parts := []string{fmt.Sprintf("%s at %s (%s)", last, formatNearTime(l.Timestamp, now), ago)}
+
+	count := fmt.Sprintf("%v doses", l.DayDoses)
+	if l.DayDoses == 1 {
+		count = "1 dose"
+	}
+
+	switch {
+	case l.DayDoses == 0:
+		parts = append(parts, "none in the last 24h")
+	case l.DayAmount > 0:
+		parts = append(parts, fmt.Sprintf("%s in the last 24h (%s)", formatDrugAmount(l.DayAmount, l.Unit, l.Drug), count))
+	default:
+		parts = append(parts, count+" in the last 24h")
+	}
+
+	if interval, ok := config.RedoseInterval(l.Dose); ok {
+		if redose := l.End().Add(interval); redose.After(now) {
+			parts = append(parts, "OK to redose at "+formatNearTime(redose, now))
+		} else {
+			parts = append(parts, "OK to redose")
+		}
+	}
+
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Limit is a cap on how much of a drug or class can be taken in a rolling window, from "limits" in the config.
+// For example {"drug": "Caffeine", "amount": "400mg", "per": "24h"} or {"class": "stim", "days": 3, "per": "7d"}.
+type Limit struct {
+	Drug   string `json:"drug,omitempty"`
+	Class  string `json:"class,omitempty"`
+	Amount string `json:"amount,omitempty"` // total amount, converted the same way as stats, eg "400mg"
+	Doses  int    `json:"doses,omitempty"`  // number of doses
+	Days   int    `json:"days,omitempty"`   // number of 24h periods with at least one dose, counted back from the end of the window
+	Per    string `json:"per"`              // the rolling window, eg "24h" or "7d"
+	Refuse bool   `json:"refuse,omitempty"` // refuse to add doses over the limit unless -force is set, instead of warning
+
+	amount Quantity      // generated from Amount
+	per    time.Duration // generated from Per
+}
+
+// compile validates l and parses Amount and Per, classes is the name of every known class
+func (l *Limit) compile(classes []string) error {
+	switch {
+	case l.Drug == "" && l.Class == "":
+		return fmt.Errorf("limit has no drug or class: %+v", *l)
+	case l.Drug != "" && l.Class != "":
+		return fmt.Errorf("limit has both a drug and a class, use two limits instead: %+v", *l)
+	case l.Amount == "" && l.Doses == 0 && l.Days == 0:
+		return fmt.Errorf("limit of %s has no amount, doses or days", l.Name())
+	}
+
+	if l.Class != "" && !containsFold(classes, l.Class) {
+		return fmt.Errorf("limit of %s has an unknown class, known classes: %s", l.Name(), strings.Join(classes, ", "))
+	}
+
+	per, err := parseDuration(l.Per)
+	if err != nil || per <= 0 {
+		return fmt.Errorf("limit of %s has an invalid \"per\" \"%s\", eg \"24h\" or \"7d\"", l.Name(), l.Per)
+	}
+
+	l.per = per
+
+	if l.Amount != "" {
+		amount, err := parseQuantity(l.Amount)
+		if err != nil {
+			return fmt.Errorf("limit of %s: %v", l.Name(), err)
+		}
+
+		l.amount = amount
+	}
+
+	return nil
+}
+
+// Name returns the drug or class that l applies to
+func (l Limit) Name() string {
+	if l.Class != "" {
+		return l.Class
+	}
+
+	return l.Drug
+}
+
+// Matches returns true if dose counts towards l
+func (l Limit) Matches(dose Dose) bool {
+	if l.Class != "" {
+		return config.ClassMatches(l.Class, dose, options)
+	}
+
+	return sameDrug(l.Drug, dose.Drug)
+}
+
+// LimitUsage is how much of a limit was used in the window up to End
+type LimitUsage struct {
+	Limit
+	End       time.Time
+	Amount    float64 // in the unit of Limit.Amount
+	Doses     int
+	Days      int
+	Uncounted int // doses with an amount that couldn't be converted to the unit of Limit.Amount
+}
+
+// Usage returns how much of l was used by doses in the window up to and including end.
+// Days are 24h periods counted back from end rather than calendar dates, so a "7d" window has at most 7 of them.
+func (l Limit) Usage(doses []Dose, end time.Time) LimitUsage {
+	u := LimitUsage{Limit: l, End: end}
+	days := make(map[time.Duration]bool)
+
+	for _, d := range doses {
+		if d.Timestamp.After(end) || !d.Timestamp.After(end.Add(-l.per)) || !l.Matches(d) {
+			continue
+		}
+
+		u.Doses++
+		days[end.Sub(d.Timestamp)/(24*time.Hour)] = true
+
+		if l.Amount == "" {
+			continue
+		}
+
+		if amount, ok := l.convert(d); ok {
+			u.Amount += amount
+		} else {
+			u.Uncounted++
+		}
+	}
+
+	u.Days = len(days)
+	return u
+}
+
+// convert returns the amount of drug in d in the unit of l.Amount, see Dose.AmountIn.
+// Volumes are converted with the density of the drug, and "10u" of Alcohol uses the same unit as stats.
+func (l Limit) convert(d Dose) (float64, bool) {
+	return d.AmountIn(lookupDrugUnit(l.Drug, l.amount.Unit))
+}
+
+// Exceeded returns true if any part of the limit was exceeded
+func (u LimitUsage) Exceeded() bool {
+	return (u.Limit.Amount != "" && u.Amount > u.amount.Amount()) ||
+		(u.Limit.Doses != 0 && u.Doses > u.Limit.Doses) ||
+		(u.Limit.Days != 0 && u.Days > u.Limit.Days)
+}
+
+// String returns the usage of each part of the limit, eg "Caffeine: 300mg of 400mg per 24h"
+func (u LimitUsage) String() string {
+	parts := make([]string, 0)
+	if u.Limit.Amount != "" {
+		part := fmt.Sprintf("%s of %s", formatAmount(u.Amount)+u.amount.Unit, u.Limit.Amount)
+		if u.Uncounted > 0 {
+			part += fmt.Sprintf(" (%v doses in other units aren't counted)", u.Uncounted)
+		}
+
+		parts = append(parts, part)
+	}
+
+	if u.Limit.Doses != 0 {
+		parts = append(parts, fmt.Sprintf("%v of %v doses", u.Doses, u.Limit.Doses))
+	}
+
+	if u.Limit.Days != 0 {
+		parts = append(parts, fmt.Sprintf("%v of %v days", u.Days, u.Limit.Days))
+	}
+
+	return fmt.Sprintf("%s: %s per %s", u.Name(), strings.Join(parts, ", "), u.Per)
+}
+
+// checkLimits prints a warning for every limit that is exceeded by the added doses, including the doses themselves.
+// It returns false if any of them refuse new doses, unless -force is set.
+func checkLimits(doses []Dose, added ...Dose) bool {
+	refused := false
+
+	for _, l := range config.Limits {
+		var exceeded *LimitUsage
+		for _, d := range added {
+			if !l.Matches(d) {
+				continue
+			}
+
+			// A dose that was backdated counts towards later windows as well, which end at the doses after it
+			ends := []time.Time{d.Timestamp}
+			for _, later := range doses {
+				if later.Timestamp.After(d.Timestamp) && later.Timestamp.Before(d.Timestamp.Add(l.per)) && l.Matches(later) {
+					ends = append(ends, later.Timestamp)
+				}
+			}
+
+			for _, end := range ends {
+				if u := l.Usage(doses, end); u.Exceeded() && (exceeded == nil || u.End.After(exceeded.End)) {
+					exceeded = &u
+				}
+			}
+		}
+
+		if exceeded == nil {
+			continue
+		}
+
+		fmt.Printf("WARNING: over the limit for %s\n", exceeded)
+		if l.Refuse && options.Force {
+			fmt.Printf("WARNING: this limit refuses doses over it, adding anyway as -force is set\n")
+		} else if l.Refuse {
+			refused = true
+		}
+	}
+
+	if refused {
+		fmt.Printf("`%s`: nothing was added, as a limit refuses doses over it. Use -force to add it anyway\n", options.Mode)
+	}
+
+	return !refused
+}
+
+// containsFold returns true if s is in names, ignoring case
+func containsFold(names []string, s string) bool {
+	for _, name := range names {
+		if strings.EqualFold(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
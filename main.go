@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"regexp"
 	"sort"
 	"strconv"
@@ -16,15 +18,13 @@ import (
 	"time"
 	"unicode"
 
-	"github.com/thlib/go-timezone-local/tzlocal"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
 var (
-	caser       = cases.Title(language.English)
-	client      = http.DefaultClient
-	dosageRegex = regexp.MustCompile("([0-9.]+)([ -_]+)?([μµ]g|mg|g|kg|u|x|mL|)?")
+	caser  = cases.Title(language.English)
+	client = http.DefaultClient
 
 	//prefsUrl = "http://localhost:6010/media/doses-prefs.json"
 	options = &DisplayOptions{}
@@ -32,12 +32,19 @@ var (
 	loadUrl  = flag.String("url", "http://localhost:6010/media/doses.json", "URL for doses.json")
 	saveUrl  = flag.String("save-url", "", "URL for saving to a different file (used with -save-filtered)")
 	urlToken = flag.String("token", "", "token for fs-over-http (default $FOH_TOKEN or $FOH_SERVER_AUTH from env)")
-
-	optAdd = flag.Bool("add", false, "Set to add a dose")
+	cfgUrl   = flag.String("config", "", "URL or path for doses-config.json (default next to -url)")
+
+	optAdd = flag.Bool("add", false, "Set to add a dose, optionally as one line, eg: -add 20mg caffeine oral 15m ago \"with breakfast\"")
+	optRep = flag.Bool("repeat", false, "Set to add the last dose again (or the last dose of -d), at -date / -time (default \"time.Now()\")")
+	optTpl = flag.String("template", "", "Add every dose of a template from the config, eg \"morning-meds\" (-t is already used for dottime)")
+	optNrm = flag.Bool("normalize-roa", false, "Replace RoA aliases such as \"PO\" or \"Snorted\" with their canonical name for every dose (respects filters)")
+	optRen = flag.String("rename-drug", "", "Rename every dose of a drug (respects filters), the new name is the next argument, eg: -rename-drug ketamin Ketamine")
+	optAdb = flag.Bool("add-batch", false, "Set to add one dose per line from stdin (or a file argument), as one line or CSV: date,time,dosage,drug,roa,note")
 	optRm  = flag.Bool("rm", false, "Set to remove the *last added* dose")
 	optRmP = flag.Int("rmp", -1, "Set to remove dose *by position*")
 	optSav = flag.Bool("save", false, "Run a manual save to re-generate the .txt format after a manual edit")
 	optSfl = flag.Bool("save-filtered", false, "[DANGEROUS] Respect -g when using -save, WILL overwrite doses if set")
+	optDst = flag.Bool("check-dst", false, "Set to list doses logged at times that are ambiguous or skipped because of DST")
 	optTop = flag.Bool("stat-top", false, "Set to view top statistics")
 	optAvg = flag.Bool("stat-avg", false, "Set to view average dose statistics")
 	optNts = flag.Bool("ignore-notes", false, "Set to hide notes (applies before filters)")
@@ -47,18 +54,43 @@ var (
 	optR   = flag.Bool("r", false, "Show in reverse order")
 	optS   = flag.Bool("s", false, "Start reading doses from top (applies before anything else)")
 	optV   = flag.Bool("v", false, "Inverse filter for text")
-	optG   = flag.String("g", "", "Filter for text (applies in all modes)")
+	optQ   = flag.String("q", "", "Filter using a query on dose fields (applies in all modes), eg 'drug~\"amph\" and roa=insufflated and dose>20mg and date>=2024-01-01'")
+	optG   = flag.String("g", "", "Filter for text (applies in all modes), \"@name\" is replaced with the regex of a class from -config")
+	optSin = flag.String("since", "", "Only show doses since this date, eg \"2024-03-01\", \"7d\", \"yesterday\" or \"this month\" (applied before -n)")
+	optUnt = flag.String("until", "", "Only show doses until the end of this date, eg \"2024-03-31\", \"yesterday\" or \"last month\" (applied before -n)")
+	optPos = flag.String("pos", "", "Only show doses in a range of positions, eg \"120-134\" or \"120-134,140\" (applied before -n)")
+	optY   = flag.Bool("y", false, "Don't ask for confirmation before modifying existing doses")
+	optFrc = flag.Bool("force", false, "Add a dose of a new drug, even if its name is close to a drug that was already logged, or a dose over a limit from -config")
 	optN   = flag.Int("n", 0, "Show last n doses, -1 = all (applied after filters, does not apply to -save-filtered)")
-
-	aChangeTz = flag.String("change-tz", "", "Change timezone (retain literal date / time) (applies to last -n doses)")
-	aConvTz   = flag.String("convert-tz", "", "Convert timezone (shift relative date / time) (applies to last -n doses)")
-	aTimezone = flag.String("timezone", "", "Set timezone")
-	aDate     = flag.String("date", "", "Set date (default \"time.Now()\")")
-	aTime     = flag.String("time", "", "Set time (default \"time.Now()\")")
+	optCat = flag.String("category", "", "Filter by category, eg \"therapeutic\" or \"recreational\" (comma separated, applies in all modes)")
+	optCfs = flag.String("category-files", "therapeutic.txt", "Comma separated list of category files next to -config, each line is a regex matched against \"date,drug,note\"")
+	optCls = flag.String("class", "", "Filter by class from -config, \",\" for union and \"+\" for intersection, eg \"stim+amph,opiate\" (inverted by -v)")
+	optGrp = flag.String("group", "drug", "Group stats by \"drug\", \"category\", \"class\" or \"roa\"")
+	optAct = flag.Bool("active", false, "Set to estimate how much of each drug is still active, from the half-life in the substance database")
+	optLst = flag.Bool("last", false, "Set to show the last dose of each drug, how long ago it was, and when it's OK to redose from -config")
+	optLim = flag.Bool("limits", false, "Set to show the usage of every limit from -config, for all doses (ignores filters)")
+	optInt = flag.Bool("interactions", false, "Set to check the drugs that are active now for interactions, and with any drugs given as arguments, eg: -interactions MDMA")
+	optTol = flag.Bool("tolerance", false, "Set to estimate the tolerance to each drug and cross-tolerant class, and when it's back to baseline")
+	optThr = flag.String("threshold", "5%", "Amount that -active considers a drug active above, as a percentage of the last dose, or eg \"10mg\" (skips drugs in other units)")
+	optBio = flag.Bool("bioavailable", false, "Show the estimated amount that reached the bloodstream in stats, from the bioavailability of each drug by RoA")
+
+	aChangeTz = flag.String("change-tz", "", "Change timezone (retain literal date / time) (applies to last -n doses, or -pos / -since / -until)")
+	aConvTz   = flag.String("convert-tz", "", "Convert timezone (shift relative date / time) (applies to last -n doses, or -pos / -since / -until)")
+	aTimezone = flag.String("timezone", "", "Set timezone (default from -tz-from timeline, or the most recent dose)")
+	aTzFrom   = flag.String("tz-from", "", "Record being in a timezone from -date / -time (default \"time.Now()\"), used when adding doses without -timezone")
+	aFold     = flag.String("fold", "", "Pick the \"earlier\" or \"later\" time when -time is ambiguous or skipped because of DST")
+	optTzTl   = flag.Bool("tz-timeline", false, "Show the timezone timeline recorded with -tz-from")
+	aDate     = flag.String("date", "", "Set date, \"yesterday\", a weekday (\"mon\"), an ISO 8601 timestamp or a unix timestamp (default \"time.Now()\")")
+	aTime     = flag.String("time", "", "Set time, or a time relative to now, eg \"-1h30m\" (default \"time.Now()\")")
+	aEnd      = flag.String("end", "", "Set end time for doses that aren't instantaneous, eg \"16:30\" or \"+1h30m\"")
+	aDuration = flag.String("duration", "", "Set duration for doses that aren't instantaneous, eg \"1h30m\"")
+	aAgo      = flag.String("ago", "", "Set time as a duration ago, eg \"45m\" or \"1h30m\" (same as -time -45m)")
 	aDosage   = flag.String("a", "", "Set dosage")
 	aDrug     = flag.String("d", "", "Set drug name")
 	aRoa      = flag.String("roa", "", "Set RoA")
 	aNote     = flag.String("note", "", "Add note")
+	optUnit   = flag.String("unit", "", "Show dosages in a unit, eg \"mg\", or \"auto\" for 1000μg → 1mg (default the preferred unit from -config, or as typed)")
+	aSolution = flag.String("solution", "", "Set solution from the config for a volumetric dose, eg \"pregabalin-50\" (default -d from the solution)")
 )
 
 //type MainPreferences struct {
@@ -75,6 +107,9 @@ type Mode int64
 const (
 	ModeGet Mode = iota
 	ModeAdd
+	ModeAddBatch
+	ModeRepeat
+	ModeTemplate
 	ModeRm
 	ModeRmPosition
 	ModeTzChange
@@ -83,6 +118,16 @@ const (
 	ModeSaveFiltered
 	ModeStatTop
 	ModeStatAvg
+	ModeTzFrom
+	ModeTzTimeline
+	ModeCheckDst
+	ModeRenameDrug
+	ModeNormalizeRoa
+	ModeActive
+	ModeLast
+	ModeLimits
+	ModeInteractions
+	ModeTolerance
 )
 
 func (m Mode) String() string {
@@ -91,6 +136,12 @@ func (m Mode) String() string {
 		return "-get"
 	case ModeAdd:
 		return "-add"
+	case ModeAddBatch:
+		return "-add-batch"
+	case ModeRepeat:
+		return "-repeat"
+	case ModeTemplate:
+		return "-template"
 	case ModeRm:
 		return "-rm"
 	case ModeRmPosition:
@@ -107,6 +158,26 @@ func (m Mode) String() string {
 		return "-stat-top"
 	case ModeStatAvg:
 		return "-stat-avg"
+	case ModeTzFrom:
+		return "-tz-from"
+	case ModeTzTimeline:
+		return "-tz-timeline"
+	case ModeCheckDst:
+		return "-check-dst"
+	case ModeRenameDrug:
+		return "-rename-drug"
+	case ModeNormalizeRoa:
+		return "-normalize-roa"
+	case ModeActive:
+		return "-active"
+	case ModeLast:
+		return "-last"
+	case ModeLimits:
+		return "-limits"
+	case ModeInteractions:
+		return "-interactions"
+	case ModeTolerance:
+		return "-tolerance"
 	default:
 		return "-default"
 	}
@@ -126,21 +197,35 @@ type TimestampLayout struct {
 
 type DisplayOptions struct {
 	Mode
-	Json         bool
-	Unix         bool
-	DotTime      bool
-	IgnoreNotes  bool
-	Reversed     bool
-	StartAtTop   bool
-	FilterInvert bool
-	Filter       string
-	FilterRegex  *regexp.Regexp // generated from Filter
-	LastAddedPos int            // when Mode is ModeAdd this is set after adding a dose
-	Show         int
-	RmPosition   int
-	Timezone     string
-	LoadUrl      string // generated from loadUrl / saveUrl, used by saveDoseFiles()
-	SaveUrl      string // generated from loadUrl / saveUrl, used by saveDoseFiles()
+	Json           bool
+	Unix           bool
+	DotTime        bool
+	IgnoreNotes    bool
+	Reversed       bool
+	StartAtTop     bool
+	FilterInvert   bool
+	Filter         string
+	FilterRegex    *regexp.Regexp // generated from Filter
+	Query          string
+	QueryFilter    *Query         // generated from Query
+	Categories     []string       // generated from optCat
+	Classes        ClassFilter    // generated from optCls
+	Positions      PositionRanges // generated from optPos
+	Since          time.Time      // generated from optSin
+	Until          time.Time      // generated from optUnt
+	LastAddedPos   int            // when Mode is ModeAdd this is set after adding a dose
+	Show           int
+	Unit           string // from -unit, shown for every dose that can be converted
+	PreferredUnits bool   // use the preferred unit for each drug from the config when Unit isn't set
+	RmPosition     int
+	Confirmed      bool
+	Force          bool
+	StatGroup      string
+	Bioavailable   bool // from -bioavailable, only used by stat modes
+	Timezone       string
+	Fold           Fold   // generated from aFold
+	LoadUrl        string // generated from loadUrl / saveUrl, used by saveDoseFiles()
+	SaveUrl        string // generated from loadUrl / saveUrl, used by saveDoseFiles()
 }
 
 func (d *DisplayOptions) Parse() {
@@ -148,6 +233,12 @@ func (d *DisplayOptions) Parse() {
 	switch {
 	case *optAdd:
 		mode = ModeAdd
+	case *optAdb:
+		mode = ModeAddBatch
+	case *optRep:
+		mode = ModeRepeat
+	case *optTpl != "":
+		mode = ModeTemplate
 	case *optRm:
 		mode = ModeRm
 	case *optRmP > -1:
@@ -156,6 +247,16 @@ func (d *DisplayOptions) Parse() {
 		mode = ModeTzChange
 	case *aConvTz != "":
 		mode = ModeTzConvert
+	case *aTzFrom != "":
+		mode = ModeTzFrom
+	case *optTzTl:
+		mode = ModeTzTimeline
+	case *optDst:
+		mode = ModeCheckDst
+	case *optRen != "":
+		mode = ModeRenameDrug
+	case *optNrm:
+		mode = ModeNormalizeRoa
 	case *optSfl:
 		mode = ModeSaveFiltered
 	case *optSav:
@@ -164,13 +265,24 @@ func (d *DisplayOptions) Parse() {
 		mode = ModeStatTop
 	case *optAvg:
 		mode = ModeStatAvg
+	case *optAct:
+		mode = ModeActive
+	case *optLst:
+		mode = ModeLast
+	case *optLim:
+		mode = ModeLimits
+	case *optInt:
+		mode = ModeInteractions
+	case *optTol:
+		mode = ModeTolerance
 	default:
 		mode = ModeGet
 	}
 
-	// If we're not in a stat mode and the user hasn't set showLast, set it to 5 as a sensible default
+	// If we're not in a stat mode and the user hasn't set showLast, set it to 5 as a sensible default.
+	// If a time range is set, we want to show every dose in the range instead.
 	showLast := *optN
-	if showLast == 0 && mode != ModeStatTop && mode != ModeStatAvg {
+	if showLast == 0 && mode != ModeStatTop && mode != ModeStatAvg && mode != ModeCheckDst && mode != ModeRenameDrug && mode != ModeNormalizeRoa && mode != ModeActive && mode != ModeLast && mode != ModeLimits && mode != ModeInteractions && mode != ModeTolerance && *optSin == "" && *optUnt == "" && *optPos == "" {
 		showLast = 5
 	}
 
@@ -180,10 +292,19 @@ func (d *DisplayOptions) Parse() {
 		timezone = *aChangeTz
 	case *aConvTz != "":
 		timezone = *aConvTz
+	case *aTzFrom != "":
+		timezone = *aTzFrom
 	case *aTimezone != "":
 		timezone = *aTimezone
 	}
 
+	categoryFilter := make([]string, 0)
+	for _, c := range strings.Split(*optCat, ",") {
+		if c = strings.ToLower(strings.TrimSpace(c)); c != "" {
+			categoryFilter = append(categoryFilter, c)
+		}
+	}
+
 	saveUrlNew := *loadUrl
 	if len(*saveUrl) > 0 {
 		saveUrlNew = *saveUrl
@@ -200,12 +321,22 @@ func (d *DisplayOptions) Parse() {
 		FilterInvert: *optV,
 		Filter:       *optG,
 		//FilterRegex: set after Parse(),
-		LastAddedPos: -1,
-		Show:         showLast,
-		RmPosition:   *optRmP,
-		Timezone:     timezone,
-		LoadUrl:      *loadUrl,
-		SaveUrl:      saveUrlNew,
+		Query: *optQ,
+		//QueryFilter: set after Parse(),
+		Categories:     categoryFilter,
+		Classes:        ParseClassFilter(*optCls),
+		LastAddedPos:   -1,
+		Show:           showLast,
+		Unit:           *optUnit,
+		PreferredUnits: true,
+		RmPosition:     *optRmP,
+		Confirmed:      *optY,
+		Force:          *optFrc,
+		StatGroup:      strings.ToLower(*optGrp),
+		Bioavailable:   *optBio,
+		Timezone:       timezone,
+		LoadUrl:        *loadUrl,
+		SaveUrl:        saveUrlNew,
 	}
 }
 
@@ -229,21 +360,111 @@ func (d *DisplayOptions) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// Matches returns true if dose matches every filter set in d
+func (d *DisplayOptions) Matches(dose Dose) bool {
+	// -v inverts both -g and -class, so that `-class excl -v` works the same as `-g "$DOSE_EXCL" -v` did
+	if d.FilterRegex != nil || len(d.Classes) > 0 {
+		matched := (d.FilterRegex == nil || d.FilterRegex.MatchString(dose.FilterString(d))) &&
+			(len(d.Classes) == 0 || d.Classes.Matches(dose, d))
+
+		if d.FilterInvert == matched {
+			return false
+		}
+	}
+
+	if len(d.Positions) > 0 && !d.Positions.Contains(dose.Position) {
+		return false
+	}
+
+	if !d.Since.IsZero() && dose.Timestamp.Before(d.Since) {
+		return false
+	}
+
+	if !d.Until.IsZero() && !dose.Timestamp.Before(d.Until) {
+		return false
+	}
+
+	if d.QueryFilter != nil && !d.QueryFilter.Match(dose, d) {
+		return false
+	}
+
+	if len(d.Categories) > 0 {
+		category := categories.Get(dose)
+		found := false
+
+		for _, c := range d.Categories {
+			if c == category {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// StatGroupKeys returns the names of the stats that dose should be counted in, see -group
+func (d *DisplayOptions) StatGroupKeys(dose Dose) []string {
+	switch d.StatGroup {
+	case "category":
+		return []string{caser.String(categories.Get(dose))}
+	case "class":
+		keys := make([]string, 0)
+		for _, name := range config.ClassNames() {
+			if config.ClassMatches(name, dose, d) {
+				keys = append(keys, name)
+			}
+		}
+
+		if len(keys) == 0 {
+			keys = append(keys, "Unclassified")
+		}
+
+		return keys
+	case "roa":
+		// Group aliases together, so that history from before -normalize-roa isn't split up
+		roa, _ := normalizeRoa(dose.RoA)
+		if roa == "" {
+			roa = "Unknown"
+		}
+
+		return []string{roa}
+	default:
+		return []string{dose.Drug}
+	}
+}
+
 type TimeData struct {
 	Timestamp time.Time `json:"timestamp,omitempty"`
 	Timezone  string    `json:"timezone,omitempty"`
 }
 
-type Dose struct { // timezone,date,time,dosage,drug,roa,note
+type Dose struct { // timezone,date,time,duration,dosage,drug,roa,note
 	Position int `json:"position"` // order added, at the top so that it's marshaled as at the top
 	TimeData
-	Created *TimeData `json:"created,omitempty"`
-	Date    string    `json:"date,omitempty"`
-	Time    string    `json:"time,omitempty"`
-	Dosage  string    `json:"dosage,omitempty"`
-	Drug    string    `json:"drug,omitempty"`
-	RoA     string    `json:"roa,omitempty"`
-	Note    string    `json:"note,omitempty"`
+	Created  *TimeData `json:"created,omitempty"`
+	Date     string    `json:"date,omitempty"`
+	Time     string    `json:"time,omitempty"`     // 15:04, or 15:04:05 if the dose has seconds
+	Duration string    `json:"duration,omitempty"` // for doses that aren't instantaneous, eg 1h30m
+	Dosage   string    `json:"dosage,omitempty"`
+	Quantity *Quantity `json:"quantity,omitempty"` // parsed from Dosage when adding, see ParsedQuantity()
+	Solution string    `json:"solution,omitempty"` // name of a solution from the config, for volumetric doses
+	Drug     string    `json:"drug,omitempty"`
+	RoA      string    `json:"roa,omitempty"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// timeLayout returns the layout used to parse Date and Time, as Time can optionally have seconds
+func (d Dose) timeLayout() string {
+	if len(d.Time) > len("15:04") {
+		return "2006/01/0215:04:05"
+	}
+
+	return "2006/01/0215:04"
 }
 
 func (d Dose) ParsedTime() (time.Time, error) {
@@ -253,13 +474,37 @@ func (d Dose) ParsedTime() (time.Time, error) {
 		return timeZero, err
 	}
 
-	if pt, err := time.ParseInLocation("2006/01/0215:04", d.Date+d.Time, loc); err == nil {
+	if pt, err := time.ParseInLocation(d.timeLayout(), d.Date+d.Time, loc); err == nil {
 		return pt, nil
 	} else {
 		return timeZero, err
 	}
 }
 
+// ParsedWallClock returns the literal Date and Time of d in UTC, see parseWallClock
+func (d Dose) ParsedWallClock() (time.Time, error) {
+	return time.Parse(d.timeLayout(), d.Date+d.Time)
+}
+
+// ParsedDuration returns Duration, or 0 if the dose is instantaneous
+func (d Dose) ParsedDuration() time.Duration {
+	if d.Duration == "" {
+		return 0
+	}
+
+	duration, err := parseDuration(d.Duration)
+	if err != nil || duration < 0 {
+		return 0
+	}
+
+	return duration
+}
+
+// End returns when the dose finished, which is the same as Timestamp for instantaneous doses
+func (d Dose) End() time.Time {
+	return d.Timestamp.Add(d.ParsedDuration())
+}
+
 func (d Dose) StringOptions(options *DisplayOptions) string {
 	note := ""
 	if !options.IgnoreNotes && d.Note != "" {
@@ -268,7 +513,17 @@ func (d Dose) StringOptions(options *DisplayOptions) string {
 
 	dosage := ""
 	if d.Dosage != "" {
-		dosage = " " + d.Dosage
+		dosage = " " + d.DisplayDosage(options)
+	}
+
+	// show the amount of drug as well as the volume of the solution
+	if d.Solution != "" {
+		dosage += " of " + d.Solution
+		if q, err := d.ParsedQuantity(); err == nil {
+			if amount, unit, err := d.Total(); err == nil && unit != q.Unit {
+				dosage += " (" + formatAmount(amount) + unit + ")"
+			}
+		}
 	}
 
 	unix := ""
@@ -276,6 +531,17 @@ func (d Dose) StringOptions(options *DisplayOptions) string {
 		unix = fmt.Sprintf("%v ", d.Timestamp.Unix())
 	}
 
+	// only show seconds if the dose was logged with them
+	seconds := ""
+	if d.Timestamp.Second() != 0 {
+		seconds = ":05"
+	}
+
+	duration := ""
+	if pd := d.ParsedDuration(); pd > 0 {
+		duration = " (" + formatDuration(pd) + ")"
+	}
+
 	// print dottime format
 	if options.DotTime {
 		zone := d.Timestamp.Format("Z07")
@@ -283,147 +549,105 @@ func (d Dose) StringOptions(options *DisplayOptions) string {
 			zone = "+00"
 		}
 
-		return fmt.Sprintf("%s%s%s %s, %s%s", unix, d.Timestamp.UTC().Format("2006-01-02 15·04")+zone, dosage, d.Drug, d.RoA, note)
+		return fmt.Sprintf("%s%s%s%s %s, %s%s", unix, d.Timestamp.UTC().Format("2006-01-02 15·04"+strings.ReplaceAll(seconds, ":", "·"))+zone, duration, dosage, d.Drug, d.RoA, note)
 	}
 
 	// print regular format
-	return fmt.Sprintf("%s%s%s %s, %s%s", unix, d.Timestamp.Format("2006/01/02 15:04"), dosage, d.Drug, d.RoA, note)
+	return fmt.Sprintf("%s%s%s%s %s, %s%s", unix, d.Timestamp.Format("2006/01/02 15:04"+seconds), duration, dosage, d.Drug, d.RoA, note)
+}
+
+// StringTimezone is used to preview timezone changes, it shows the position, UTC offset and timezone name of d
+func (d Dose) StringTimezone() string {
+	return fmt.Sprintf("%v: %s %s (%s) %s %s", d.Position, d.Date, d.Time, d.Timestamp.Format("-07:00"), d.Timezone, d.Drug)
 }
 
 func (d Dose) String() string {
 	return d.StringOptions(options)
 }
 
-// DoseUnitSize represents a dose unit as a factor of micrograms equivalent
-type DoseUnitSize int64
-
-const (
-	DoseUnitSizeDefault    DoseUnitSize = 0
-	DoseUnitSizeMicrogram  DoseUnitSize = 1
-	DoseUnitSizeMilliliter DoseUnitSize = -1 // TODO: FIX
-	DoseUnitSizeMilligram  DoseUnitSize = 1000
-	DoseUnitSizeGram       DoseUnitSize = 1000 * 1000
-	DoseUnitSizeKilogram   DoseUnitSize = 1000 * 1000 * 1000
-	DoseUnitSizeAlcohol    DoseUnitSize = DoseUnitSizeEthanol / 10 // 1u  = 0.1mL of EtOH = 1 SI unit of Alcohol
-	DoseUnitSizeEthanol    DoseUnitSize = 789.45 * 1000            // 1mL = 789.45mg EtOH at 20°C * to get micrograms
-	DoseUnitSizeGHB        DoseUnitSize = 1120.0 * 1000            // 1mL = 1120.0mg of GHB at 25°C * to get μg
-	DoseUnitSizeGBL        DoseUnitSize = 1129.6 * 1000            // 1mL = 1129.6mg of GBL at 20°C * to get μg
-	DoseUnitSizeBDO        DoseUnitSize = 1017.3 * 1000            // 1mL = 1017.3mg of 1,4-BDO at 25°C * to get μg
-)
-
-func (u DoseUnitSize) String() string {
-	switch u {
-	case DoseUnitSizeMicrogram:
-		return "μg"
-	case DoseUnitSizeMilligram, DoseUnitSizeGHB:
-		return "mg"
-	case DoseUnitSizeGram:
-		return "g"
-	case DoseUnitSizeKilogram:
-		return "kg"
-	case DoseUnitSizeEthanol, DoseUnitSizeGBL, DoseUnitSizeBDO:
-		return "mL"
-	case DoseUnitSizeMilliliter, DoseUnitSizeAlcohol:
-		return "u"
-	default:
-		return ""
-	}
+// FilterString returns the line that -g and classes are matched against.
+// The dosage is kept as it was logged, so that a filter matches the same doses regardless of -unit or preferred units.
+func (d Dose) FilterString(options *DisplayOptions) string {
+	o := *options
+	o.Unit, o.PreferredUnits = "", false
+	return d.StringOptions(&o)
 }
 
-func (u DoseUnitSize) F() float64 {
-	return float64(u)
+type DoseStat struct {
+	Drug          string
+	TotalDoses    int64
+	TotalDuration time.Duration // exposure time, from doses that have a duration
+	DurationDoses int64         // doses that have a duration, used to average TotalDuration
+	TotalVolume   float64       // in mL, from doses of a solution
+	TotalAmount   float64       // in micrograms
+	Available     float64       // from -bioavailable, the estimated part of TotalAmount that reached the bloodstream
+	Assumed       int64         // from -bioavailable, doses without a known bioavailability that are counted as fully available
+	UnitLabel     string        // See UnitOrLabel(): only set if no unit is known
+	Unit          Unit          // a mass unit, or the zero Unit if no dose had a known mass
+	OriginalUnit  Unit
 }
 
-type DoseStat struct {
-	Drug         string
-	TotalDoses   int64
-	TotalAmount  float64 // in micrograms
-	UnitLabel    string  // See UnitOrLabel(): only set if no unit is known
-	Unit         DoseUnitSize
-	OriginalUnit DoseUnitSize
+// AddDuration adds the exposure time of a dose, doses without a duration aren't counted
+func (s *DoseStat) AddDuration(d time.Duration) {
+	if d > 0 {
+		s.TotalDuration += d
+		s.DurationDoses++
+	}
 }
 
-// ToUnit converts the TotalAmount to a new DoseUnitSize
-// TODO: Generify and use in normal doses
-func (s *DoseStat) ToUnit(u DoseUnitSize) {
-	if u == DoseUnitSizeDefault || u == s.Unit {
+// ToUnit converts the TotalAmount to another mass unit, individual doses are converted with Unit.Convert()
+func (s *DoseStat) ToUnit(u Unit) {
+	if u.Family != UnitFamilyMass || s.Unit.Family != UnitFamilyMass || u == s.Unit {
 		return
 	}
 
-	if s.Unit == DoseUnitSizeMicrogram {
-		if s.Unit < u {
-			s.TotalAmount = s.TotalAmount / u.F()
-		} else {
-			s.TotalAmount = s.TotalAmount * u.F()
-		}
-	} else {
-		if s.Unit < u {
-			s.TotalAmount = s.TotalAmount * s.Unit.F() / u.F()
-		} else {
-			s.TotalAmount = s.TotalAmount / s.Unit.F() * u.F()
-		}
-	}
+	s.TotalAmount = s.TotalAmount * s.Unit.Size / u.Size
+	s.Available = s.Available * s.Unit.Size / u.Size
 
 	s.Unit = u
 }
 
-// ToSensibleUnit will convert to a larger weight unit if it's value is larger than 1000.
+// ToSensibleUnit will convert to a larger mass unit if it's value is larger than 1000, see Unit.Sensible
 func (s *DoseStat) ToSensibleUnit() {
-	for _, u := range []DoseUnitSize{DoseUnitSizeMicrogram, DoseUnitSizeMilligram, DoseUnitSizeGram} {
-		switch s.Unit {
-		case u:
-			if s.TotalAmount >= 1000 {
-				s.ToUnit(u * 1000)
-			}
-		}
+	// units that are specific to a drug, such as "u" of Alcohol, are kept as they are
+	if lookupUnit(s.Unit.Name) == s.Unit {
+		s.ToUnit(s.Unit.Sensible(s.TotalAmount))
 	}
 }
 
-// UnitOrLabel will return Unit as a string, or UnitLabel if it's Unit is not known.
+// UnitOrLabel will return the name of Unit, or UnitLabel if it's Unit is not known.
 func (s *DoseStat) UnitOrLabel() string {
-	if s.Unit.String() != "" {
-		return s.Unit.String()
+	if s.Unit.Name != "" {
+		return s.Unit.Name
 	}
 
 	return s.UnitLabel
 }
 
-func ParseUnit(d, u string) DoseUnitSize {
-unit:
-	switch u {
-	case "", "?":
-		break unit
-	case "u":
-		switch d {
-		case "Alcohol":
-			return DoseUnitSizeAlcohol
-		default:
-			break unit
-		}
-	case "mL":
-		switch d {
-		case "EtOH", "Ethanol":
-			return DoseUnitSizeEthanol
-		case "GHB":
-			return DoseUnitSizeGHB
-		case "GBL":
-			return DoseUnitSizeGBL
-		case "BDO":
-			return DoseUnitSizeBDO
-		default:
-			return DoseUnitSizeMilliliter
-		}
-	case "kg":
-		return DoseUnitSizeKilogram
-	case "g":
-		return DoseUnitSizeGram
-	case "mg":
-		return DoseUnitSizeMilligram
-	default:
-		return DoseUnitSizeMicrogram
+// AddAmount will add amount to TotalAmount, amount is expected to be in micrograms if unit is a mass
+func (s *DoseStat) AddAmount(amount float64, unitLabel string, unit Unit) {
+	s.Unit = unit
+
+	// Only set `s.OriginalUnit` if it hasn't been set before
+	if s.OriginalUnit.Name == "" {
+		s.OriginalUnit = unit
+
+		if s.UnitLabel == "" {
+			s.UnitLabel = unitLabel
+		}
+	}
+
+	// Nothing else to do, skip
+	if amount == 0 {
+		return
+	}
+
+	// We want to set the unit of this stat if it's a mass
+	if unit.Name != "" {
+		s.Unit = lookupUnit("μg")
 	}
 
-	return DoseUnitSizeDefault
+	s.TotalAmount += amount
 }
 
 func (s *DoseStat) Format(n1, n2 int) string {
@@ -438,14 +662,7 @@ func (s *DoseStat) Format(n1, n2 int) string {
 	)
 	f1 += strings.Repeat(" ", n1-len(f1))
 
-	f2 := strings.TrimRight(
-		strings.TrimRight(
-			fmt.Sprintf(
-				"%.2f",
-				s.TotalAmount,
-			), "0",
-		), ".",
-	) + s.UnitOrLabel()
+	f2 := formatAmount(s.TotalAmount) + s.UnitOrLabel()
 
 	// TODO: Make offset dynamic
 	offset = n2 - len(f2) + offset
@@ -454,6 +671,28 @@ func (s *DoseStat) Format(n1, n2 int) string {
 	}
 	f2 += strings.Repeat(" ", offset)
 
+	extra := make([]string, 0)
+	if options.Bioavailable && s.Available > 0 {
+		available := "~" + formatAmount(s.Available) + s.UnitOrLabel() + " bioavailable"
+		if s.Assumed > 0 {
+			available += fmt.Sprintf(" assuming 100%% for %v doses", s.Assumed)
+		}
+
+		extra = append(extra, available)
+	}
+
+	if s.TotalVolume > 0 {
+		extra = append(extra, formatAmount(s.TotalVolume)+"mL")
+	}
+
+	if s.TotalDuration > 0 {
+		extra = append(extra, formatDuration(s.TotalDuration)+" exposure")
+	}
+
+	if len(extra) > 0 {
+		return f1 + f2 + s.Drug + " (" + strings.Join(extra, ", ") + ")"
+	}
+
 	return f1 + f2 + s.Drug
 }
 
@@ -462,14 +701,38 @@ func main() {
 	options.Parse()
 	loadEnv()
 
-	if options.FilterInvert && options.Filter == "" {
-		fmt.Printf("-v is set but no -g filter is set? Can't invert filter without a filter to invert!\n")
+	if options.FilterInvert && options.Filter == "" && len(options.Classes) == 0 {
+		fmt.Printf("-v is set but no -g or -class filter is set? Can't invert filter without a filter to invert!\n")
+		return
+	}
+
+	// Load config before anything else, as -g and -class depend on it
+	if *cfgUrl == "" {
+		*cfgUrl = strings.TrimSuffix(options.LoadUrl, ".json") + "-config.json"
+	}
+
+	if c, err := loadConfig(*cfgUrl); err != nil {
+		fmt.Printf("failed to load config: %v\n", err)
+		return
+	} else {
+		config = c
+	}
+
+	if err := options.Classes.Validate(config); err != nil {
+		fmt.Printf("-class is set but %v\n", err)
 		return
 	}
 
 	// ModeGet, ModeTzChange, ModeTzConvert, ModeStatTop, ModeStatAvg
 	// We do not filter in ModeRm and ModeAdd for performance reasons
 	if options.Filter != "" {
+		if filter, err := config.ExpandPresets(options.Filter); err != nil {
+			fmt.Printf("-g is set but %v\n", err)
+			return
+		} else {
+			options.Filter = filter
+		}
+
 		if filter, err := regexp.Compile(fmt.Sprintf("(?i)%s", options.Filter)); err != nil {
 			fmt.Printf("-g is set but failed to compile regex: %s\n", err)
 			return
@@ -478,6 +741,90 @@ func main() {
 		}
 	}
 
+	// -q, -since and -until resolve relative dates and times, such as "today", in -timezone if it's set
+	filterNow := time.Now()
+	if options.Timezone != "" {
+		if loc, err := time.LoadLocation(options.Timezone); err == nil {
+			filterNow = filterNow.In(loc)
+		}
+	}
+
+	if options.Query != "" {
+		if q, err := ParseQuery(options.Query, filterNow); err != nil {
+			fmt.Printf("-q is set but failed to parse query: %v\n", err)
+			return
+		} else {
+			options.QueryFilter = q
+		}
+	}
+
+	if *aAgo != "" {
+		if *aTime != "" {
+			fmt.Printf("-ago is set but -time is also set, only one can be used!\n")
+			return
+		}
+
+		*aTime = "-" + strings.TrimPrefix(*aAgo, "-")
+	}
+
+	if f, err := ParseFold(*aFold); err != nil {
+		fmt.Printf("-fold is set but %v\n", err)
+		return
+	} else {
+		options.Fold = f
+	}
+
+	if *optPos != "" {
+		if r, err := ParsePositionRanges(*optPos); err != nil {
+			fmt.Printf("-pos is set but %v\n", err)
+			return
+		} else {
+			options.Positions = r
+		}
+	}
+
+	if *optSin != "" {
+		if start, _, err := parseTimeRange(*optSin, filterNow); err != nil {
+			fmt.Printf("-since is set but %v\n", err)
+			return
+		} else {
+			options.Since = start
+		}
+	}
+
+	if *optUnt != "" {
+		if _, end, err := parseTimeRange(*optUnt, filterNow); err != nil {
+			fmt.Printf("-until is set but %v\n", err)
+			return
+		} else {
+			options.Until = end
+		}
+	}
+
+	// Only load category files when they're needed
+	if len(options.Categories) > 0 || options.StatGroup == "category" || options.QueryFilter.Uses("category") {
+		if c, err := loadCategories(*optCfs, *cfgUrl); err != nil {
+			fmt.Printf("failed to load categories: %v\n", err)
+			return
+		} else {
+			categories = c
+		}
+
+		for _, c := range options.Categories {
+			if !categories.Known(c) {
+				fmt.Printf("-category is set but \"%s\" is not a known category! Known categories: %s\n", c, strings.Join(categories.Names(), ", "))
+				return
+			}
+		}
+	}
+
+	switch options.StatGroup {
+	case "drug", "category", "class", "roa":
+	default:
+		fmt.Printf("-group is set but \"%s\" is not a valid group! Valid groups: drug, category, class, roa\n", options.StatGroup)
+		return
+	}
+
 	var err error
 	var doses []Dose
 	//var prefs MainPreferences
@@ -487,6 +834,17 @@ func main() {
 		return // already handled
 	}
 
+	// The timezone timeline is only needed when adding doses
+	switch options.Mode {
+	case ModeAdd, ModeAddBatch, ModeRepeat, ModeTemplate, ModeTzFrom, ModeTzTimeline:
+		if t, err := loadTimezones(timezonesUrl(options.LoadUrl)); err != nil {
+			fmt.Printf("failed to load timezones: %v\n", err)
+			return
+		} else {
+			timezones = t
+		}
+	}
+
 	//err = getJsonFromUrl(&prefs, prefsUrl)
 	//if err != nil {
 	//	return // already handled
@@ -557,167 +915,148 @@ func main() {
 
 		fmt.Printf("%s", getDosesFmt(doses))
 	case ModeAdd:
-		// Ensure `-drug` is set
-		if *aDrug == "" {
-			fmt.Printf("`-drug` is not set!\n")
+		in := DoseInputFromFlags()
+		oneLine := len(flag.Args()) > 0
+
+		if oneLine {
+			if err := in.MergeOneLine(flag.Args()); err != nil {
+				fmt.Printf("`%s`: %v\n", ModeAdd, err)
+				return
+			}
+		}
+
+		dose, err := newDose(doses, in)
+
+		// Offer to use the known drug instead, as a typo would otherwise show up as a new drug in stats forever
+		var typo *DrugTypoError
+		if errors.As(err, &typo) && confirm(fmt.Sprintf("\"%s\" has never been logged, did you mean \"%s\"?", typo.Drug, typo.Suggestion)) {
+			in.Drug = typo.Suggestion
+			dose, err = newDose(doses, in)
+		}
+
+		if err != nil {
+			fmt.Printf("`%s`: %v\n", ModeAdd, err)
 			return
-		} else {
-			*aDrug = caseFmt(*aDrug)
 		}
 
-		//
-		// Get timezone from the most chronologically recent dose; if `-timezone` isn't set
-		if options.Timezone == "" {
-			if len(doses) > 0 {
-				options.Timezone = doses[len(doses)-1].Timezone
-			} else {
-				fmt.Printf("`-timezone` is not set and no doses with a timezone were found! You must set a timezone to add doses first\n")
+		// Show how the one-line entry was interpreted, as it's easy for a word to end up in the wrong field
+		if oneLine && !options.Confirmed {
+			fmt.Printf("+ %s\n", dose.StringOptions(options))
+			if !confirm("Add this dose?") {
 				return
 			}
 		}
 
-		// Get timezone locations as a time.Location now
+		doses = addDoses(doses, dose)
+		if !checkLimits(doses, dose) {
+			return
+		}
 
-		// Used for timezone in normal timestamp / timezone
-		loc, err := time.LoadLocation(options.Timezone)
+		printInteractions(doses, dose)
+
+		if !saveFileWrapper(doses, false) {
+			return
+		}
+
+		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeAddBatch:
+		f, err := openBatch(flag.Arg(0))
 		if err != nil {
-			fmt.Printf("`%s`: failed to load location: %v\n", ModeAdd, err)
+			fmt.Printf("`%s`: %v\n", ModeAddBatch, err)
 			return
 		}
 
-		// Used for timezone in created timestamp / timezone
-		locTZ, err := tzlocal.RuntimeTZ()
+		inputs, err := readBatch(f)
+		f.Close()
 		if err != nil {
-			fmt.Printf("`%s`: failed to get system timezone: %v\n", ModeAdd, err)
+			fmt.Printf("`%s`: nothing was added, failed to parse:\n%v\n", ModeAddBatch, err)
+			return
+		}
+
+		if len(inputs) == 0 {
+			fmt.Printf("`%s`: no doses to add\n", ModeAddBatch)
 			return
 		}
 
-		locCreated, err := time.LoadLocation(locTZ)
+		added, err := newDoses(doses, inputs)
 		if err != nil {
-			fmt.Printf("`%s`: failed to load location: %v\n", ModeAdd, err)
+			fmt.Printf("`%s`: nothing was added, invalid doses:\n%v\n", ModeAddBatch, err)
 			return
 		}
 
-		//
-		// Parse provided `-date` and `-time` flags, using pre-defined valid layouts
-		t := time.Now().In(loc)
-		pDate := "00000101" // default to earliest possible time.Time()
-		pTime := "0000"
-
-		switch len(*aDate) {
-		case 5: // 01-02 → 2006-01-02
-			pDate = t.Format("2006-") + *aDate
-		case 4: // 0102  → 20060102
-			pDate = t.Format("2006") + *aDate
-		case 2: // 02    → 2006/01/02
-			pDate = t.Format("2006/01/") + *aDate
-		case 0: // unset → 2006/01/02 (first in LayoutFormat, so it parses the fastest)
-			pDate = t.Format("2006/01/02")
-		default: // determined by user, will try to parse
-			pDate = *aDate
-		}
-
-		switch len(*aTime) {
-		case 0: // unset → 1504 (only one matching len == 4, so it parses the fastest)
-			pTime = t.Format("1504")
-		default: // determined by user, will try to parse
-			pTime = *aTime
-		}
-
-		parseLayout := func(p string, l *TimestampLayout) (*time.Time, error) {
-			for _, f := range l.Formats {
-				// faster than waiting for time.ParseInLocation to fail
-				if len(p) != len(f) {
-					continue
-				}
+		doses = addDoses(doses, added...)
+		if !checkLimits(doses, added...) {
+			return
+		}
 
-				if ts, err := time.ParseInLocation(
-					fmt.Sprintf("%s%s%s", l.Layout.Prefix, f, l.Layout.Suffix),
-					fmt.Sprintf("%s%s%s", l.Value.Prefix, p, l.Value.Suffix),
-					loc,
-				); err == nil {
-					return &ts, nil
-				}
-			}
+		printInteractions(doses, added...)
 
-			return nil, errors.New(fmt.Sprintf(
-				"`%s`: failed to parse \"%s\" using layouts: %s",
-				ModeAdd, p, strings.Join(strings.Fields(fmt.Sprint(l.Formats)), ", "),
-			))
+		if !saveFileWrapper(doses, false) {
+			return
 		}
 
-		// Parse `-date` flag, using 00:00 as the suffix
-		if ts, err := parseLayout(pDate, &TimestampLayout{
-			[]LayoutFormat{"2006/01/02", "2006-01-02", "01/02/2006", "01-02-2006", "20060102", "01-02", "0102", "02"},
-			WrapFormat{Suffix: "1504"}, WrapFormat{Suffix: "0000"},
-		}); err != nil {
-			fmt.Printf("%v\n", err)
+		fmt.Printf("`%s`: added %v doses\n", ModeAddBatch, len(added))
+		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeRepeat:
+		// -d selects which drug to repeat, so it isn't used as an override
+		flags := DoseInputFromFlags()
+		flags.Drug = ""
+
+		in, err := repeatInput(doses, *aDrug)
+		if err != nil {
+			fmt.Printf("`%s`: %v\n", ModeRepeat, err)
 			return
-		} else {
-			t = *ts
 		}
 
-		// Parse `-time` flag, using the date we found as a prefix
-		if ts, err := parseLayout(pTime, &TimestampLayout{
-			[]LayoutFormat{"3:04pm", "15:04", "3:04", "1504"},
-			WrapFormat{Prefix: "20060102"}, WrapFormat{Prefix: t.Format("20060102")},
-		}); err != nil {
-			fmt.Printf("%v\n", err)
+		dose, err := newDose(doses, in.Override(flags))
+		if err != nil {
+			fmt.Printf("`%s`: %v\n", ModeRepeat, err)
 			return
-		} else {
-			t = *ts
 		}
 
-		//
-		// Parse -a and -d flags for dosage and drug
-		// Replace mathematical symbols in dosage with their greek variation:
-		dosage := *aDosage
-		dosage = strings.ReplaceAll(dosage, "µ", "μ") // U+00B5 → U+03BC
-		dosage = strings.ReplaceAll(dosage, "∆", "Δ") // U+2206 → U+0394
+		doses = addDoses(doses, dose)
+		if !checkLimits(doses, dose) {
+			return
+		}
+
+		printInteractions(doses, dose)
 
-		// Replace dosage ml with mL
-		if strings.HasSuffix(dosage, "ml") {
-			dosage = strings.TrimSuffix(dosage, "ml")
-			dosage += "mL"
+		if !saveFileWrapper(doses, false) {
+			return
 		}
 
-		if *aRoa == "" {
-			*aRoa = "Oral" // Default RoA. TODO: Proper handling / ask user for default.
-		} else {
-			*aRoa = caseFmt(*aRoa)
-		}
-
-		pos, _ := lastPosition(doses)
-		dose := Dose{
-			Position: pos + 1,
-			Created: &TimeData{
-				Timestamp: time.Now().In(locCreated),
-				Timezone:  locTZ,
-			},
-			TimeData: TimeData{
-				Timestamp: t,
-				Timezone:  options.Timezone,
-			},
-			Date:   t.Format("2006/01/02"),
-			Time:   t.Format("15:04"),
-			Dosage: dosage,
-			Drug:   *aDrug,
-			RoA:    *aRoa,
-			Note:   *aNote,
-		}
-
-		doses = append(doses, dose)
-		options.LastAddedPos = dose.Position
-
-		// Re-sort by chronological date and time, to handle adding a dose in the past
-		sort.Slice(doses, func(i, j int) bool {
-			return doses[i].Timestamp.Unix() < doses[j].Timestamp.Unix()
-		})
+		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeTemplate:
+		template, err := config.Template(*optTpl)
+		if err != nil {
+			fmt.Printf("`%s`: %v\n", ModeTemplate, err)
+			return
+		}
+
+		flags := DoseInputFromFlags()
+		inputs := make([]DoseInput, 0)
+		for _, t := range template {
+			inputs = append(inputs, t.Input(flags))
+		}
+
+		added, err := newDoses(doses, inputs)
+		if err != nil {
+			fmt.Printf("`%s`: nothing was added, invalid doses:\n%v\n", ModeTemplate, err)
+			return
+		}
+
+		doses = addDoses(doses, added...)
+		if !checkLimits(doses, added...) {
+			return
+		}
+
+		printInteractions(doses, added...)
 
 		if !saveFileWrapper(doses, false) {
 			return
 		}
 
+		fmt.Printf("`%s`: added %v doses\n", ModeTemplate, len(added))
 		fmt.Printf("%s", getDosesFmt(doses))
 	case ModeTzChange, ModeTzConvert:
 		if len(doses) == 0 {
@@ -727,12 +1066,13 @@ func main() {
 
 		loc, err := time.LoadLocation(options.Timezone)
 		if err != nil {
-			fmt.Printf("`%s`: failed to load location: %v\n", ModeAdd, err)
+			fmt.Printf("`%s`: failed to load location: %v\n", options.Mode, err)
 			return
 		}
 
 		dosesFiltered := getDosesOptions(doses, options)
 		dosePositions := make(map[string]int) // [position]index
+		dosesModified := make([]Dose, 0)
 
 		for n, d := range doses {
 			dosePositions[strconv.Itoa(d.Position)] = n
@@ -741,21 +1081,50 @@ func main() {
 		for _, d := range dosesFiltered {
 			switch options.Mode {
 			case ModeTzChange:
-				d.Timestamp = time.Date(
+				wallClock := resolveWallClock(time.Date(
 					d.Timestamp.Year(), d.Timestamp.Month(), d.Timestamp.Day(),
 					d.Timestamp.Hour(), d.Timestamp.Minute(), d.Timestamp.Second(), d.Timestamp.Nanosecond(),
-					loc)
+					time.UTC), loc)
+				if warning := wallClock.Warning(options.Fold); warning != "" {
+					fmt.Printf("Warning: %v: %s\n", d.Position, warning)
+				}
+
+				d.Timestamp = wallClock.Pick(options.Fold)
 				d.Timezone = options.Timezone
+				d.Date = d.Timestamp.Format("2006/01/02")
+				d.Time = formatClock(d.Timestamp)
 			case ModeTzConvert:
 				d.Timestamp = d.Timestamp.In(loc)
 				d.Timezone = options.Timezone
 				d.Date = d.Timestamp.Format("2006/01/02")
-				d.Time = d.Timestamp.Format("15:04")
+				d.Time = formatClock(d.Timestamp)
 			default:
 				fmt.Printf("`%s`: modifying dose in non-supported mode?? how?\n", options.Mode)
 				return
 			}
 
+			dosesModified = append(dosesModified, d)
+		}
+
+		if len(dosesModified) == 0 {
+			fmt.Printf("`%s`: no doses matched, nothing to modify\n", options.Mode)
+			return
+		}
+
+		// Show a preview of old vs new timestamps, as it's easy to modify the wrong doses after travelling
+		fmt.Printf("`%s`: modifying %v doses:\n", options.Mode, len(dosesModified))
+		for _, d := range dosesModified {
+			if n, ok := dosePositions[strconv.Itoa(d.Position)]; ok {
+				fmt.Printf("- %s\n+ %s\n", doses[n].StringTimezone(), d.StringTimezone())
+			}
+		}
+
+		if !options.Confirmed && !confirm("Save changes?") {
+			fmt.Printf("`%s`: not saving changes\n", options.Mode)
+			return
+		}
+
+		for _, d := range dosesModified {
 			if n, ok := dosePositions[strconv.Itoa(d.Position)]; ok {
 				doses[n] = d
 			}
@@ -766,6 +1135,258 @@ func main() {
 		}
 
 		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeRenameDrug:
+		name := strings.TrimSpace(strings.Join(flag.Args(), " "))
+		if name == "" {
+			fmt.Printf("`%s`: no new name is set, eg: -rename-drug ketamin Ketamine\n", options.Mode)
+			return
+		}
+
+		// Use the canonical name if the new name is known, so that aliases can be migrated with `-rename-drug Xanax alprazolam`
+		if substance := config.substances.Get(name); substance != nil {
+			name = substance.Name
+		}
+
+		renamed := make(map[int]string) // [index]old name
+		for n, d := range doses {
+			if strings.EqualFold(d.Drug, *optRen) && d.Drug != name && options.Matches(d) {
+				renamed[n] = d.Drug
+				doses[n].Drug = name
+			}
+		}
+
+		if len(renamed) == 0 {
+			fmt.Printf("`%s`: no doses of \"%s\" need to be renamed to \"%s\"\n", options.Mode, *optRen, name)
+			return
+		}
+
+		fmt.Printf("`%s`: renaming %v doses:\n", options.Mode, len(renamed))
+		for n, d := range doses {
+			if old, ok := renamed[n]; ok {
+				fmt.Printf("- %v: %s\n+ %v: %s\n", d.Position, old, d.Position, d.Drug)
+			}
+		}
+
+		if !options.Confirmed && !confirm("Save changes?") {
+			fmt.Printf("`%s`: not saving changes\n", options.Mode)
+			return
+		}
+
+		if !saveFileWrapper(doses, false) {
+			return
+		}
+
+		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeNormalizeRoa:
+		normalized := make(map[int]string) // [index]old RoA
+		unknown := make(map[string]int)    // [RoA]doses
+
+		for n, d := range doses {
+			if !options.Matches(d) {
+				continue
+			}
+
+			roa, ok := normalizeRoa(d.RoA)
+			if !ok {
+				unknown[d.RoA]++
+			} else if roa != d.RoA {
+				normalized[n] = d.RoA
+				doses[n].RoA = roa
+			}
+		}
+
+		unknownRoas := make([]string, 0)
+		for roa := range unknown {
+			unknownRoas = append(unknownRoas, roa)
+		}
+
+		sort.Strings(unknownRoas)
+		for _, roa := range unknownRoas {
+			fmt.Printf("Warning: \"%s\" is not a known RoA (%v doses), add it to \"roas\" in the config to normalize it\n", roa, unknown[roa])
+		}
+
+		if len(normalized) == 0 {
+			fmt.Printf("`%s`: every RoA is already normalized\n", options.Mode)
+			return
+		}
+
+		fmt.Printf("`%s`: normalizing %v doses:\n", options.Mode, len(normalized))
+		for n, d := range doses {
+			if old, ok := normalized[n]; ok {
+				fmt.Printf("- %v: %s %s\n+ %v: %s %s\n", d.Position, d.Drug, old, d.Position, d.Drug, d.RoA)
+			}
+		}
+
+		if !options.Confirmed && !confirm("Save changes?") {
+			fmt.Printf("`%s`: not saving changes\n", options.Mode)
+			return
+		}
+
+		if !saveFileWrapper(doses, false) {
+			return
+		}
+
+		fmt.Printf("%s", getDosesFmt(doses))
+	case ModeTzFrom:
+		loc, err := time.LoadLocation(options.Timezone)
+		if err != nil {
+			fmt.Printf("`%s`: failed to load location: %v\n", options.Mode, err)
+			return
+		}
+
+		t, err := parseDateTime(*aDate, *aTime, loc)
+		if err != nil {
+			fmt.Printf("`%s`: %v\n", options.Mode, err)
+			return
+		}
+
+		timezones = timezones.Add(TimezoneEntry{From: t, Timezone: options.Timezone})
+		if !saveTimezones(timezones) {
+			return
+		}
+
+		fmt.Printf("%s", timezones)
+	case ModeTzTimeline:
+		if len(timezones) == 0 {
+			fmt.Printf("`%s`: no timezones recorded yet, use `-tz-from` to record one\n", options.Mode)
+			return
+		}
+
+		fmt.Printf("%s", timezones)
+	case ModeCheckDst:
+		found := 0
+
+		for _, d := range getDosesOptions(doses, options) {
+			loc, err := time.LoadLocation(d.Timezone)
+			if err != nil {
+				fmt.Printf("%v: failed to load location: %v\n", d.Position, err)
+				continue
+			}
+
+			wall, err := d.ParsedWallClock()
+			if err != nil {
+				fmt.Printf("%v: failed to parse date / time: %v\n", d.Position, err)
+				continue
+			}
+
+			wallClock := resolveWallClock(wall, loc)
+			switch wallClock.Kind {
+			case WallClockGap:
+				fmt.Printf("%s is skipped by DST\n", d.StringTimezone())
+			case WallClockAmbiguous:
+				fold := FoldLater
+				if d.Timestamp.Equal(wallClock.Earlier) {
+					fold = FoldEarlier
+				}
+
+				fmt.Printf("%s is ambiguous, logged as the %s time\n", d.StringTimezone(), fold)
+			default:
+				continue
+			}
+
+			found++
+		}
+
+		if found == 0 {
+			fmt.Printf("`%s`: no doses were logged at ambiguous times\n", options.Mode)
+		}
+	case ModeActive:
+		threshold, err := parseActiveThreshold(*optThr)
+		if err != nil {
+			fmt.Printf("-threshold is set but %v\n", err)
+			return
+		}
+
+		now := currentTime(doses)
+		active, unknown := activeDrugs(getDosesOptions(doses, options), threshold, now)
+
+		for _, a := range active {
+			fmt.Printf("%s\n", a.Format(now))
+		}
+
+		if len(active) == 0 {
+			fmt.Printf("`%s`: no drugs are above the threshold\n", options.Mode)
+		}
+
+		if len(unknown) > 0 {
+			fmt.Printf("No half-life is known for %s, add \"half_life\" to \"substances\" in the config to include them\n", strings.Join(unknown, ", "))
+		}
+	case ModeLast:
+		now := currentTime(doses)
+		last := lastDoses(getDosesOptions(doses, options), now)
+
+		for _, l := range last {
+			fmt.Printf("%s\n", l.Format(now))
+		}
+
+		if len(last) == 0 {
+			fmt.Printf("`%s`: no doses found\n", options.Mode)
+		}
+	case ModeLimits:
+		if len(config.Limits) == 0 {
+			fmt.Printf("`%s`: no limits are set, add \"limits\" to the config, eg {\"drug\": \"Caffeine\", \"amount\": \"400mg\", \"per\": \"24h\"}\n", options.Mode)
+			return
+		}
+
+		now := currentTime(doses)
+		for _, l := range config.Limits {
+			u := l.Usage(doses, now)
+			if u.Exceeded() {
+				fmt.Printf("%s (OVER)\n", u)
+			} else {
+				fmt.Printf("%s\n", u)
+			}
+		}
+	case ModeInteractions:
+		now := currentTime(doses)
+
+		active := make([]Dose, 0)
+		for _, d := range getDosesOptions(doses, options) {
+			if start, end := interactionWindow(d); !start.After(now) && end.After(now) {
+				active = append(active, d)
+			}
+		}
+
+		// Drugs given as arguments are checked as if they were taken now
+		checked := active
+		if len(flag.Args()) > 0 {
+			checked = make([]Dose, 0)
+			for n, drug := range flag.Args() {
+				checked = append(checked, Dose{Position: -1 - n, TimeData: TimeData{Timestamp: now}, Drug: canonicalDrug(doses, drug)})
+			}
+
+			active = append(active, checked...)
+		}
+
+		found := findInteractions(active, checked...)
+		for _, di := range found {
+			fmt.Printf("%s\n", di.String(now))
+		}
+
+		drugs := make([]string, 0)
+		for _, d := range active {
+			if !containsFold(drugs, d.Drug) {
+				drugs = append(drugs, d.Drug)
+			}
+		}
+
+		switch {
+		case len(drugs) == 0:
+			fmt.Printf("`%s`: no drugs are active\n", options.Mode)
+		case len(found) == 0:
+			fmt.Printf("`%s`: no known interactions between %s\n", options.Mode, strings.Join(drugs, ", "))
+		}
+	case ModeTolerance:
+		now := currentTime(doses)
+		rows := tolerances(getDosesOptions(doses, options), now)
+
+		for _, t := range rows {
+			fmt.Printf("%s\n", t.Format(now))
+		}
+
+		if len(rows) == 0 {
+			fmt.Printf("`%s`: no tolerance above baseline\n", options.Mode)
+		}
 	case ModeStatTop, ModeStatAvg:
 		doses = getDosesOptions(doses, options)
 
@@ -780,58 +1401,89 @@ func main() {
 		// stat.TotalAmount is in MICROGRAMS right now
 		// increment total doses and total amount for each drug
 		for _, d := range doses {
-			stat := stats[d.Drug]
-			stat.Drug = d.Drug
-			stat.TotalDoses += 1
+			groups := options.StatGroupKeys(d)
 			statTotal.TotalDoses += 1
-			stats[d.Drug] = stat // we still want to save the stat, so we can increment the total doses even if the dosage is not set or fails to parse
+			statTotal.AddDuration(d.ParsedDuration())
+
+			// we still want to save the stat, so we can increment the total doses even if the dosage is not set or fails to parse
+			for _, group := range groups {
+				stat := stats[group]
+				stat.Drug = group
+				stat.TotalDoses += 1
+				stat.AddDuration(d.ParsedDuration())
+				stats[group] = stat
+			}
 
-			units := dosageRegex.FindStringSubmatch(d.Dosage)
-			if len(units) != 4 {
-				continue
+			// Show the volume of solutions alongside the amount of drug
+			if d.Solution != "" {
+				for _, group := range groups {
+					stat := stats[group]
+					stat.TotalVolume += d.Volume()
+					stats[group] = stat
+				}
 			}
 
-			amount, err := strconv.ParseFloat(units[1], 64)
+			// Get unitSize for current dose
+			amount, unitLabel, err := d.Total()
 			if err != nil {
 				continue
 			}
 
-			// Get unitSize for current dose
-			unitLabel := units[3]
-			unitSize := ParseUnit(stat.Drug, unitLabel)
-			stat.Unit = unitSize
-
-			// Only set `stat.OriginalUnit` if it hasn't been set before
-			if stat.OriginalUnit == DoseUnitSizeDefault {
-				stat.OriginalUnit = unitSize
+			// Volumes are shown in mL for each drug, but are added to the total as a mass using the density of the drug
+			micrograms := lookupUnit("μg")
+			total, hasMass := d.AmountIn(micrograms)
+			if !hasMass {
+				total = amount
+			}
 
-				if stat.UnitLabel == "" {
-					stat.UnitLabel = unitLabel
+			// We want to set total specifically here, in case we have a scenario where no doses have any units to go off of
+			if amount != 0 {
+				if hasMass {
+					statTotal.Unit = micrograms
+					statTotal.OriginalUnit = micrograms
+				} else if statTotal.UnitLabel == "" {
+					statTotal.UnitLabel = unitLabel // Add a fallback label if it is a default unit size
 				}
+
+				statTotal.TotalAmount += total
 			}
 
-			// Nothing else to do, skip
-			if amount == 0 {
-				stats[d.Drug] = stat
-				continue
+			// Convert masses to micrograms, so they are converted back to the original unit later
+			unit := lookupDrugUnit(d.Drug, unitLabel)
+			if unit.Family == UnitFamilyMass {
+				amount = amount * unit.Size
+			} else {
+				unit = Unit{}
 			}
 
-			// We want to set the unit size of this stat if it isn't default.
-			// We also want to set total specifically here, in case we have a scenario where no doses have any units to go off of
-			if unitSize != DoseUnitSizeDefault {
-				stat.Unit = DoseUnitSizeMicrogram
-				statTotal.Unit = DoseUnitSizeMicrogram
-				statTotal.OriginalUnit = DoseUnitSizeMicrogram
-
-				// Convert amount to micrograms, set unit, so it is converted back to original later
-				amount = amount * unitSize.F()
-			} else if statTotal.UnitLabel == "" {
-				statTotal.UnitLabel = unitLabel // Add a fallback label if it is a default unit size
+			// Estimate how much reached the bloodstream, doses with an unknown bioavailability are counted in full
+			fraction, assumed := 1.0, int64(0)
+			if options.Bioavailable && amount != 0 {
+				if f, ok := config.substances.Get(d.Drug).AvailableFraction(d.RoA); ok {
+					fraction = f
+				} else {
+					assumed = 1
+				}
+
+				statTotal.Available += total * fraction
+				statTotal.Assumed += assumed
 			}
 
-			stat.TotalAmount += amount
-			statTotal.TotalAmount += amount
-			stats[d.Drug] = stat
+			// Groups other than drug mix drugs, so volumes are added as a mass like the total
+			if options.StatGroup != "drug" && hasMass && unit.Name == "" {
+				amount, unit = total, micrograms
+			}
+
+			// A dose can be in more than one group when using -group class, but should only be counted once in the total
+			for _, group := range groups {
+				stat := stats[group]
+				stat.AddAmount(amount, unitLabel, unit)
+				if options.Bioavailable && amount != 0 {
+					stat.Available += amount * fraction
+					stat.Assumed += assumed
+				}
+				stats[group] = stat
+			}
 		}
 
 		//
@@ -848,7 +1500,7 @@ func main() {
 		// Always ensures that the Total / Average stat is always at the bottom.
 		sort.SliceStable(statsOrdered, func(i, j int) bool {
 			if statsOrdered[i].TotalDoses == statsOrdered[j].TotalDoses {
-				if statsOrdered[i].TotalAmount*statsOrdered[i].Unit.F() == statsOrdered[j].TotalAmount*statsOrdered[j].Unit.F() {
+				if statsOrdered[i].TotalAmount*statsOrdered[i].Unit.Size == statsOrdered[j].TotalAmount*statsOrdered[j].Unit.Size {
 					greekI := unicode.Is(unicode.Greek, []rune(statsOrdered[i].Drug)[0])
 					greekJ := unicode.Is(unicode.Greek, []rune(statsOrdered[j].Drug)[0])
 					if greekI && !greekJ {
@@ -861,7 +1513,7 @@ func main() {
 					return strings.Compare(statsOrdered[i].Drug, statsOrdered[j].Drug) <= 0
 				}
 
-				return statsOrdered[i].TotalAmount*statsOrdered[i].Unit.F() < statsOrdered[j].TotalAmount*statsOrdered[j].Unit.F()
+				return statsOrdered[i].TotalAmount*statsOrdered[i].Unit.Size < statsOrdered[j].TotalAmount*statsOrdered[j].Unit.Size
 			}
 
 			return statsOrdered[i].TotalDoses < statsOrdered[j].TotalDoses
@@ -875,13 +1527,28 @@ func main() {
 			v.ToUnit(v.OriginalUnit)
 
 			// convert total amount to average amount
-			if options.Mode == ModeStatAvg {
+			if options.Mode == ModeStatAvg && v.TotalDoses > 0 {
 				v.TotalAmount = v.TotalAmount / float64(v.TotalDoses)
+				v.Available = v.Available / float64(v.TotalDoses)
+				v.TotalVolume = v.TotalVolume / float64(v.TotalDoses)
+			}
+
+			// the average exposure is only of the doses that have a duration
+			if options.Mode == ModeStatAvg && v.DurationDoses > 0 {
+				v.TotalDuration = v.TotalDuration / time.Duration(v.DurationDoses)
 			}
 
 			// convert from micrograms to larger units if too big
 			v.ToSensibleUnit()
 
+			// use -unit or the preferred unit of the drug instead, if it's a mass
+			unit := options.Unit
+			if unit == "" {
+				unit = config.PreferredUnit(v.Drug)
+			}
+
+			v.ToUnit(lookupUnit(unit))
+
 			statsOrdered[k] = v
 		}
 		// stat.TotalAmount is **NOT IN MICROGRAMS ANYMORE**
@@ -923,6 +1590,51 @@ func getJsonFromUrl(v any, path string) error {
 	return nil
 }
 
+// isUrl returns true if path should be read with readFileOrUrl from a server, rather than from disk
+func isUrl(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// pathNextTo returns path relative to the directory of base, which can be a URL or a path on disk.
+// Absolute paths and URLs are returned as-is.
+func pathNextTo(base, path string) string {
+	switch {
+	case path == "", isUrl(path), filepath.IsAbs(path):
+		return path
+	case isUrl(base):
+		return base[:strings.LastIndex(base, "/")+1] + path
+	default:
+		return filepath.Join(filepath.Dir(base), path)
+	}
+}
+
+// readFileOrUrl will read path from fs-over-http (or any other server) if it is a URL, otherwise from disk
+func readFileOrUrl(path string) ([]byte, error) {
+	if !isUrl(path) {
+		return os.ReadFile(path)
+	}
+
+	response, err := http.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch response.StatusCode {
+	case 200:
+		return b, nil
+	case 404:
+		return nil, fmt.Errorf("%w: %s", os.ErrNotExist, path)
+	default:
+		return nil, fmt.Errorf("status code was %v: %s", response.StatusCode, b)
+	}
+}
+
 func caseFmt(s string) string {
 	if s == "" {
 		return s
@@ -947,9 +1659,8 @@ func caseFmt(s string) string {
 	}
 
 	// If it Starts with a lowercase letter, uppercase it.
-	// TODO: This will not work for something like 3-HO-PCP. Need better solution.
-	// Simply checking for a number isn't enough, as 4-PrO-DMT wouldn't work.
-	// A database of drug names or using the user's last casing when unsure would probably be the way to go.
+	// This will not work for something like 3-HO-PCP, simply checking for a number isn't enough, as 4-PrO-DMT wouldn't work.
+	// Known drugs are named by the substance database or the user's last casing instead, see canonicalDrug().
 	if removeGreek(s[:1]) != removeGreek(strings.ToUpper(s[:1])) {
 		return caser.String(s)
 	}
@@ -1123,14 +1834,9 @@ func getDosesOptions(doses []Dose, options *DisplayOptions) []Dose {
 
 	dosesFiltered := make([]Dose, 0)
 
-	if options.FilterRegex == nil {
-		dosesFiltered = append(dosesFiltered, dosesTrans...)
-	} else {
-		for _, d := range dosesTrans {
-			if (options.LastAddedPos != -1 && options.LastAddedPos == d.Position) ||
-				options.FilterInvert != options.FilterRegex.MatchString(d.StringOptions(options)) {
-				dosesFiltered = append(dosesFiltered, d)
-			}
+	for _, d := range dosesTrans {
+		if (options.LastAddedPos != -1 && options.LastAddedPos == d.Position) || options.Matches(d) {
+			dosesFiltered = append(dosesFiltered, d)
 		}
 	}
 
@@ -1149,6 +1855,66 @@ func getDosesOptions(doses []Dose, options *DisplayOptions) []Dose {
 	return dosesCut
 }
 
+// PositionRanges is a list of inclusive ranges of dose positions, see -pos
+type PositionRanges [][2]int
+
+func ParsePositionRanges(s string) (PositionRanges, error) {
+	r := make(PositionRanges, 0)
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		start, end, isRange := strings.Cut(part, "-")
+
+		from, err := strconv.Atoi(strings.TrimSpace(start))
+		if err != nil {
+			return r, fmt.Errorf("failed to parse position \"%s\": %v", part, err)
+		}
+
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(strings.TrimSpace(end)); err != nil {
+				return r, fmt.Errorf("failed to parse position \"%s\": %v", part, err)
+			}
+		}
+
+		if to < from {
+			return r, fmt.Errorf("position range \"%s\" ends before it starts", part)
+		}
+
+		r = append(r, [2]int{from, to})
+	}
+
+	return r, nil
+}
+
+func (r PositionRanges) Contains(pos int) bool {
+	for _, p := range r {
+		if pos >= p[0] && pos <= p[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// confirm will ask the user a yes / no question on stdin, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Printf("\n")
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
 func SliceRemoveIndex[T comparable](s []T, i int) []T {
 	return append(s[:i], s[i+1:]...)
 }
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// oneLineDosageTokens is the most words a dosage can be split over, eg "0.5 mL of 1 mg/mL"
+const oneLineDosageTokens = 7
+
+var (
+	oneLineTimeRegex = regexp.MustCompile(`^\d{1,2}(:\d{2}(:\d{2})?)?([ap]m)?$`)
+	oneLineUnitRegex = regexp.MustCompile(`^[0-9.]+[A-Za-zμµ]+$`) // a dosage with an unknown unit, but not a drug like 2C-B
+)
+
+// oneLineToken is a single word of a one-line entry, quoted tokens are always treated as a note
+type oneLineToken struct {
+	Value  string
+	Quoted bool
+}
+
+// splitOneLine will split s into words, keeping "quoted text" as a single token
+func splitOneLine(s string) ([]oneLineToken, error) {
+	tokens := make([]oneLineToken, 0)
+	var sb strings.Builder
+	quote := rune(0)
+
+	flush := func(quoted bool) {
+		if sb.Len() > 0 || quoted {
+			tokens = append(tokens, oneLineToken{Value: sb.String(), Quoted: quoted})
+		}
+		sb.Reset()
+	}
+
+	for _, c := range s {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+			flush(true)
+		case quote != 0:
+			sb.WriteRune(c)
+		case c == '"' || c == '\'':
+			flush(false)
+			quote = c
+		case unicode.IsSpace(c):
+			flush(false)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+
+	if quote != 0 {
+		return tokens, fmt.Errorf("unterminated quote in \"%s\"", s)
+	}
+
+	flush(false)
+	return tokens, nil
+}
+
+// parseOneLine will parse a one-line entry such as `20mg caffeine oral 15m ago "with breakfast"`.
+// args are the arguments after the flags, either as a single quoted argument or already split by the shell.
+// Everything that isn't recognized as a dosage, RoA, date, time or note is used as the drug name.
+func parseOneLine(args []string) (DoseInput, error) {
+	in := DoseInput{}
+	tokens := make([]oneLineToken, 0)
+
+	for _, arg := range args {
+		// The shell has already removed quotes, so an argument with spaces must have been quoted
+		if len(args) > 1 && strings.IndexFunc(arg, unicode.IsSpace) != -1 {
+			tokens = append(tokens, oneLineToken{Value: arg, Quoted: true})
+			continue
+		}
+
+		t, err := splitOneLine(arg)
+		if err != nil {
+			return in, err
+		}
+
+		tokens = append(tokens, t...)
+	}
+
+	drug := make([]string, 0)
+	problems := make([]string, 0)
+
+	set := func(field *string, name, value string) {
+		if *field != "" {
+			problems = append(problems, fmt.Sprintf("found more than one %s: \"%s\" and \"%s\"", name, *field, value))
+			return
+		}
+
+		*field = value
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		lower := strings.ToLower(t.Value)
+		next := ""
+		if i+1 < len(tokens) && !tokens[i+1].Quoted {
+			next = strings.ToLower(tokens[i+1].Value)
+		}
+
+		dosage, dosageTokens := "", 0
+		if !t.Quoted {
+			dosage, dosageTokens = oneLineDosage(tokens[i:])
+		}
+
+		switch {
+		case t.Quoted:
+			set(&in.Note, "note", t.Value)
+		case next == "ago":
+			if _, err := parseDuration(lower); err != nil {
+				problems = append(problems, fmt.Sprintf("\"%s ago\": %v", t.Value, err))
+			}
+
+			set(&in.Time, "time", "-"+lower)
+			i++
+		case lower == "at" && next != "":
+			set(&in.Time, "time", next)
+			i++
+		case lower == "on" && next != "":
+			set(&in.Date, "date", next)
+			i++
+		case isRelativeTime(lower) && durationRegex.MatchString(lower):
+			set(&in.Time, "time", lower)
+		case oneLineTimeRegex.MatchString(lower) && (strings.Contains(lower, ":") || strings.HasSuffix(lower, "m")):
+			set(&in.Time, "time", lower)
+		case dosageTokens > 0:
+			set(&in.Dosage, "dosage", dosage)
+			i += dosageTokens - 1
+		case isOneLineRoa(lower):
+			roa, _ := normalizeRoa(lower)
+			set(&in.RoA, "RoA", roa)
+		case isOneLineDate(lower):
+			set(&in.Date, "date", lower)
+		default:
+			drug = append(drug, t.Value)
+		}
+	}
+
+	in.Drug = strings.Join(drug, " ")
+
+	// Try to give some helpful suggestions for words that were used as the drug name
+	for _, word := range drug {
+		lower := strings.ToLower(word)
+
+		switch {
+		case strings.HasPrefix(word, "-"):
+			// Flags after the one-line entry aren't parsed, so `-add 20mg caffeine -y` would log "caffeine -y"
+			problems = append(problems, fmt.Sprintf("\"%s\" looks like a flag, flags must be set before the one-line entry", word))
+		case durationRegex.MatchString(lower) && durationRegex.FindString(lower) == lower:
+			problems = append(problems, fmt.Sprintf("\"%s\" looks like a time, did you mean \"%s ago\"?", word, word))
+		case oneLineUnitRegex.MatchString(word):
+			problems = append(problems, fmt.Sprintf("\"%s\" looks like a dosage but has an unknown unit, did you mean \"%s\"?", word, suggestDosage(word)))
+		default:
+			if roa := closestName(lower, roaNames(), 2); roa != "" {
+				problems = append(problems, fmt.Sprintf("\"%s\" is not a known RoA, did you mean \"%s\"?", word, roa))
+			}
+		}
+	}
+
+	if in.Drug == "" {
+		problems = append(problems, "no drug name found, expected something like `20mg caffeine oral 15m ago`")
+	}
+
+	if len(problems) > 0 {
+		return in, errors.New("ambiguous input:\n- " + strings.Join(problems, "\n- "))
+	}
+
+	return in, nil
+}
+
+// oneLineDosage returns the longest dosage at the start of tokens and how many tokens it uses, eg "1/2 tab" or "0.5mL of 1mg/mL"
+func oneLineDosage(tokens []oneLineToken) (string, int) {
+	n := len(tokens)
+	if n > oneLineDosageTokens {
+		n = oneLineDosageTokens
+	}
+
+	for ; n > 0; n-- {
+		words := make([]string, 0, n)
+		for _, t := range tokens[:n] {
+			if t.Quoted {
+				break
+			}
+
+			words = append(words, t.Value)
+		}
+
+		if s := strings.Join(words, " "); len(words) == n && isOneLineDosage(s) {
+			return s, n
+		}
+	}
+
+	return "", 0
+}
+
+// isOneLineDosage returns true if s is a dosage that parseQuantity understands, with a unit that is known to be a dosage.
+// Drugs that start with a number, such as 2C-B or 5-MeO-DMT, are parsed as a dosage with an unknown unit.
+func isOneLineDosage(s string) bool {
+	q, err := parseQuantity(s)
+	return err == nil && s[0] >= '0' && s[0] <= '9' && isDosageUnit(q.Unit)
+}
+
+// isOneLineRoa returns true if s is a known RoA or alias, see normalizeRoa
+func isOneLineRoa(s string) bool {
+	_, ok := normalizeRoa(s)
+	return ok
+}
+
+// isOneLineDate returns true if s is a date word (today, yesterday, mon) or a date in dateLayout's long formats
+func isOneLineDate(s string) bool {
+	switch s {
+	case "today", "yesterday":
+		return true
+	}
+
+	for _, weekday := range weekdays {
+		if len(s) >= 3 && strings.HasPrefix(weekday, s) {
+			return true
+		}
+	}
+
+	if _, ok, err := parseTimestamp(strings.ToUpper(s), nil); ok && err == nil {
+		return true
+	}
+
+	// Only allow dates with separators, as short numeric dates (0102) would otherwise be mistaken for a dosage
+	if !strings.ContainsAny(s, "-/") {
+		return false
+	}
+
+	_, err := parseTimestampLayout(s, &dateLayout, nil)
+	return err == nil
+}
+
+// suggestDosage returns s with the closest known dosage unit, eg 20mgs → 20mg
+func suggestDosage(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) && r != '.' })
+	if i == -1 {
+		return s
+	}
+
+	if unit := closestName(s[i:], []string{"μg", "mg", "g", "kg", "u", "x", "mL"}, 3); unit != "" {
+		return s[:i] + unit
+	}
+
+	return s[:i] + "mg"
+}
+
+// closestName returns the name in names with the smallest edit distance to s, if it is at most maxDistance
+func closestName(s string, names []string, maxDistance int) string {
+	closest, distance := "", maxDistance+1
+
+	for _, name := range names {
+		if d := levenshtein(strings.ToLower(s), strings.ToLower(name)); d < distance {
+			closest, distance = name, d
+		}
+	}
+
+	return closest
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// MergeOneLine will parse args as a one-line entry and fill in the fields of in.
+// A field that is set both by a flag and in the one-line entry is an error, rather than silently picking one.
+func (in *DoseInput) MergeOneLine(args []string) error {
+	parsed, err := parseOneLine(args)
+	if err != nil {
+		return err
+	}
+
+	fields := []struct {
+		flag   string
+		field  *string
+		parsed string
+	}{
+		{"-date", &in.Date, parsed.Date},
+		{"-time", &in.Time, parsed.Time},
+		{"-a", &in.Dosage, parsed.Dosage},
+		{"-d", &in.Drug, parsed.Drug},
+		{"-roa", &in.RoA, parsed.RoA},
+		{"-note", &in.Note, parsed.Note},
+	}
+
+	for _, f := range fields {
+		if f.parsed == "" {
+			continue
+		}
+
+		if *f.field != "" {
+			return fmt.Errorf("%s is set to \"%s\" but the one-line entry also has \"%s\"", f.flag, *f.field, f.parsed)
+		}
+
+		*f.field = f.parsed
+	}
+
+	return nil
+}
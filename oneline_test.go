@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestParseOneLine(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    DoseInput
+		wantErr bool
+	}{
+		{
+			[]string{`20mg caffeine oral 15m ago "with breakfast"`},
+			DoseInput{Time: "-15m", Dosage: "20mg", Drug: "caffeine", RoA: "Oral", Note: "with breakfast"},
+			false,
+		},
+		{
+			[]string{"20mg", "caffeine", "oral", "15m", "ago", "with breakfast"},
+			DoseInput{Time: "-15m", Dosage: "20mg", Drug: "caffeine", RoA: "Oral", Note: "with breakfast"},
+			false,
+		},
+		{
+			[]string{"15mg", "2C-B", "oral", "at", "21:30", "on", "yesterday"},
+			DoseInput{Date: "yesterday", Time: "21:30", Dosage: "15mg", Drug: "2C-B", RoA: "Oral"},
+			false,
+		},
+		{
+			[]string{"50mg", "lions", "mane", "snorted", "-1h30m"},
+			DoseInput{Time: "-1h30m", Dosage: "50mg", Drug: "lions mane", RoA: "Insufflated"},
+			false,
+		},
+		{
+			[]string{"10mg", "Foo", "3:04pm", "2024-03-01"},
+			DoseInput{Date: "2024-03-01", Time: "3:04pm", Dosage: "10mg", Drug: "Foo"},
+			false,
+		},
+		{
+			[]string{"1/2 tab xanax"},
+			DoseInput{Dosage: "1/2 tab", Drug: "xanax"},
+			false,
+		},
+		{
+			[]string{"3 puffs nicotine vape"},
+			DoseInput{Dosage: "3 puffs", Drug: "nicotine", RoA: "Vaporized"},
+			false,
+		},
+		{
+			[]string{"0.5mL of 1mg/mL LSD"},
+			DoseInput{Dosage: "0.5mL of 1mg/mL", Drug: "LSD"},
+			false,
+		},
+		{
+			[]string{"2x", "5mg", "Diazepam", "oral"},
+			DoseInput{Dosage: "2x 5mg", Drug: "Diazepam", RoA: "Oral"},
+			false,
+		},
+		{
+			[]string{"10", "mg", "5-MeO-DMT", "at", "21:00"},
+			DoseInput{Time: "21:00", Dosage: "10 mg", Drug: "5-MeO-DMT"},
+			false,
+		},
+		{
+			[]string{"10-15mg 2C-B"},
+			DoseInput{Dosage: "10-15mg", Drug: "2C-B"},
+			false,
+		},
+		{[]string{"20mg", "Foo", "2", "tabs"}, DoseInput{}, true},
+		{[]string{"20mg", "Foo", "-y"}, DoseInput{}, true},
+		{[]string{"20mg", "Foo", "--force"}, DoseInput{}, true},
+		{[]string{"20mgs", "Foo"}, DoseInput{}, true},
+		{[]string{"20mg", "Foo", "orl"}, DoseInput{}, true},
+		{[]string{"20mg", "Foo", "15m"}, DoseInput{}, true},
+		{[]string{"20mg", "30mg", "Foo"}, DoseInput{}, true},
+		{[]string{"20mg", "oral"}, DoseInput{}, true},
+		{[]string{`20mg Foo "unterminated`}, DoseInput{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseOneLine(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseOneLine(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			continue
+		}
+
+		if err == nil && got != tt.want {
+			t.Errorf("parseOneLine(%q) = %+v, want %+v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"ketamine", "ketamin", 1},
+		{"caffeine", "cafeine", 1},
+		{"oral", "orla", 2},
+		{"μg", "ug", 1},
+	}
+
+	for _, tt := range tests {
+		if got := levenshtein(tt.a, tt.b); got != tt.want {
+			t.Errorf("levenshtein(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	quantityCountRegex = regexp.MustCompile(`^([0-9.]+)\s*[x×]\s*([0-9].*)$`)
+	quantityValueRegex = regexp.MustCompile(`^([0-9.]+(?:/[0-9.]+)?)(?:\s*(?:-|–|to)\s*([0-9.]+(?:/[0-9.]+)?))?[\s_-]*(.*)$`)
+	concentrationRegex = regexp.MustCompile(`^([0-9.]+)\s*([^\s/]+)\s*/\s*([0-9.]*)\s*(\S+)$`)
+)
+
+// Quantity is a structured dosage, parsed from Dose.Dosage.
+// The original string is always kept in Dose.Dosage, as Quantity can't represent everything that can be written.
+type Quantity struct {
+	Value         float64        `json:"value"`
+	Max           float64        `json:"max,omitempty"`   // set for ranges, eg 10-15mg
+	Count         float64        `json:"count,omitempty"` // multiplier, eg 2x 5mg, 0 is the same as 1
+	Unit          string         `json:"unit,omitempty"`
+	Concentration *Concentration `json:"concentration,omitempty"` // eg 0.5mL of 1mg/mL
+}
+
+// Concentration is an amount of Unit per amount of PerUnit, eg 1mg/mL or 10mg/5mL
+type Concentration struct {
+	Value    float64 `json:"value"`
+	Unit     string  `json:"unit"`
+	PerValue float64 `json:"per_value"`
+	PerUnit  string  `json:"per_unit"`
+}
+
+// dosageCountUnits are units that aren't in units, but are still known to be part of a dosage rather than a drug name
+var dosageCountUnits = []string{
+	"u", "x", "tab", "tabs", "tablet", "tablets", "pill", "pills", "cap", "caps", "capsule", "capsules",
+	"puff", "puffs", "hit", "hits", "drop", "drops",
+}
+
+// isDosageUnit returns true if unit is empty, a mass or volume, or one of dosageCountUnits
+func isDosageUnit(unit string) bool {
+	return unit == "" || lookupUnit(unit).Family != UnitFamilyCount || containsFold(dosageCountUnits, unit)
+}
+
+// parseQuantity will parse dosages such as "20mg", "10-15mg", "1/2 tab", "2x 5mg", "0.5mL of 1mg/mL" or "3 puffs"
+func parseQuantity(s string) (Quantity, error) {
+	q := Quantity{}
+	dosage := strings.TrimSpace(strings.ReplaceAll(s, "µ", "μ"))
+	if dosage == "" {
+		return q, errors.New("dosage is empty")
+	}
+
+	// "0.5mL of 1mg/mL"
+	if i := strings.Index(strings.ToLower(dosage), " of "); i != -1 {
+		c, err := parseConcentration(strings.TrimSpace(dosage[i+4:]))
+		if err != nil {
+			return q, fmt.Errorf("failed to parse dosage \"%s\": %v", s, err)
+		}
+
+		q.Concentration = &c
+		dosage = strings.TrimSpace(dosage[:i])
+	}
+
+	// "2x 5mg", where a lone "2x" is a value with the unit "x"
+	if m := quantityCountRegex.FindStringSubmatch(dosage); m != nil {
+		count, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return q, fmt.Errorf("failed to parse count in dosage \"%s\": %v", s, err)
+		}
+
+		q.Count = count
+		dosage = m[2]
+	}
+
+	m := quantityValueRegex.FindStringSubmatch(dosage)
+	if m == nil {
+		return q, fmt.Errorf("failed to parse dosage \"%s\", expected something like 20mg, 10-15mg, 1/2 tab or 2x 5mg", s)
+	}
+
+	var err error
+	if q.Value, err = parseQuantityNumber(m[1]); err != nil {
+		return q, fmt.Errorf("failed to parse dosage \"%s\": %v", s, err)
+	}
+
+	if m[2] != "" {
+		if q.Max, err = parseQuantityNumber(m[2]); err != nil {
+			return q, fmt.Errorf("failed to parse dosage \"%s\": %v", s, err)
+		} else if q.Max < q.Value {
+			return q, fmt.Errorf("failed to parse dosage \"%s\": range is backwards", s)
+		}
+	}
+
+	q.Unit = strings.TrimSpace(m[3])
+	if strings.HasSuffix(q.Unit, "ml") {
+		q.Unit = strings.TrimSuffix(q.Unit, "ml") + "mL"
+	}
+
+	return q, nil
+}
+
+// parseQuantityNumber parses a number or a fraction, eg 0.5 or 1/2
+func parseQuantityNumber(s string) (float64, error) {
+	n, d, isFraction := strings.Cut(s, "/")
+
+	value, err := strconv.ParseFloat(n, 64)
+	if err != nil || !isFraction {
+		return value, err
+	}
+
+	denominator, err := strconv.ParseFloat(d, 64)
+	if err != nil {
+		return value, err
+	} else if denominator == 0 {
+		return value, fmt.Errorf("division by zero in \"%s\"", s)
+	}
+
+	return value / denominator, nil
+}
+
+// parseConcentration parses "1mg/mL" or "10mg/5mL"
+func parseConcentration(s string) (Concentration, error) {
+	m := concentrationRegex.FindStringSubmatch(s)
+	if m == nil {
+		return Concentration{}, fmt.Errorf("failed to parse concentration \"%s\", expected something like 1mg/mL", s)
+	}
+
+	c := Concentration{Unit: m[2], PerValue: 1, PerUnit: m[4]}
+
+	var err error
+	if c.Value, err = strconv.ParseFloat(m[1], 64); err != nil {
+		return c, fmt.Errorf("failed to parse concentration \"%s\": %v", s, err)
+	}
+
+	if m[3] != "" {
+		if c.PerValue, err = strconv.ParseFloat(m[3], 64); err != nil || c.PerValue == 0 {
+			return c, fmt.Errorf("failed to parse concentration \"%s\": invalid amount \"%s\"", s, m[3])
+		}
+	}
+
+	if strings.EqualFold(c.PerUnit, "ml") {
+		c.PerUnit = "mL"
+	}
+
+	return c, nil
+}
+
+// Amount returns the total amount in Unit, using the middle of a range and multiplying by Count
+func (q Quantity) Amount() float64 {
+	amount := q.Value
+	if q.Max != 0 {
+		amount = (q.Value + q.Max) / 2
+	}
+
+	if q.Count != 0 {
+		amount *= q.Count
+	}
+
+	return amount
+}
+
+// Total returns the total amount and its unit, converted with the concentration if the unit matches it
+func (q Quantity) Total() (float64, string) {
+	amount, unit := q.Amount(), q.Unit
+
+	if c := q.Concentration; c != nil && (strings.EqualFold(unit, c.PerUnit) || unit == "") {
+		return amount * c.Value / c.PerValue, c.Unit
+	}
+
+	return amount, unit
+}
+
+// formatAmount formats amount with at least 2 decimals or 3 significant figures, eg 1.50 → 1.5 or 0.001234 → 0.00123
+func formatAmount(amount float64) string {
+	decimals := 2
+	if amount != 0 {
+		if d := 2 - int(math.Floor(math.Log10(math.Abs(amount)))); d > decimals {
+			decimals = d
+		}
+	}
+
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(amount, 'f', decimals, 64), "0"), ".")
+}
+
+// ParsedQuantity returns the stored Quantity, or parses Dosage for doses that were added before it was stored
+func (d Dose) ParsedQuantity() (Quantity, error) {
+	if d.Quantity != nil {
+		return *d.Quantity, nil
+	}
+
+	return parseQuantity(d.Dosage)
+}
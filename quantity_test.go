@@ -0,0 +1,96 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Quantity
+		wantErr bool
+	}{
+		{"20mg", Quantity{Value: 20, Unit: "mg"}, false},
+		{"0.5", Quantity{Value: 0.5}, false},
+		{"150µg", Quantity{Value: 150, Unit: "μg"}, false},
+		{"2ml", Quantity{Value: 2, Unit: "mL"}, false},
+		{"10-15mg", Quantity{Value: 10, Max: 15, Unit: "mg"}, false},
+		{"1/2 tab", Quantity{Value: 0.5, Unit: "tab"}, false},
+		{"3 puffs", Quantity{Value: 3, Unit: "puffs"}, false},
+		{"2x 5mg", Quantity{Value: 5, Unit: "mg", Count: 2}, false},
+		{"2x", Quantity{Value: 2, Unit: "x"}, false},
+		{"0.5mL of 1mg/mL", Quantity{Value: 0.5, Unit: "mL", Concentration: &Concentration{Value: 1, Unit: "mg", PerValue: 1, PerUnit: "mL"}}, false},
+		{"5mL of 10mg/5ml", Quantity{Value: 5, Unit: "mL", Concentration: &Concentration{Value: 10, Unit: "mg", PerValue: 5, PerUnit: "mL"}}, false},
+		{"", Quantity{}, true},
+		{"?", Quantity{}, true},
+		{"~20mg", Quantity{}, true},
+		{"a pinch", Quantity{}, true},
+		{"15-10mg", Quantity{}, true},
+		{"1/0 tab", Quantity{}, true},
+		{"1mL of strong", Quantity{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseQuantity(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseQuantity(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			continue
+		}
+
+		if err == nil && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseQuantity(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQuantityTotal(t *testing.T) {
+	tests := []struct {
+		s          string
+		wantAmount float64
+		wantUnit   string
+	}{
+		{"20mg", 20, "mg"},
+		{"10-15mg", 12.5, "mg"},
+		{"2x 5mg", 10, "mg"},
+		{"0.5mL of 1mg/mL", 0.5, "mg"},
+		{"5mL of 10mg/5mL", 10, "mg"},
+		{"1 tab of 10mg/mL", 1, "tab"},
+	}
+
+	for _, tt := range tests {
+		q, err := parseQuantity(tt.s)
+		if err != nil {
+			t.Errorf("parseQuantity(%q) error = %v", tt.s, err)
+			continue
+		}
+
+		if amount, unit := q.Total(); amount != tt.wantAmount || unit != tt.wantUnit {
+			t.Errorf("parseQuantity(%q).Total() = %v %s, want %v %s", tt.s, amount, unit, tt.wantAmount, tt.wantUnit)
+		}
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{0, "0"},
+		{20, "20"},
+		{1.5, "1.5"},
+		{1234.567, "1234.57"},
+		{0.25, "0.25"},
+		{0.125, "0.125"},
+		{0.001, "0.001"},
+		{0.0012345, "0.00123"},
+		{0.000004, "0.000004"},
+		{-0.0015, "-0.0015"},
+	}
+
+	for _, tt := range tests {
+		if got := formatAmount(tt.amount); got != tt.want {
+			t.Errorf("formatAmount(%v) = %q, want %q", tt.amount, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a filter that is evaluated against the fields of a Dose, instead of the line -g matches against.
+// For example: drug~"amph" and roa=insufflated and dose>20mg and note:"work" and date>=2024-01-01
+//
+// Supported operators are:
+//   - = and != for (case-insensitive) equality
+//   - ~ and !~ for (case-insensitive) regex matching
+//   - : for (case-insensitive) substring matching
+//   - >, >=, < and <= for dose, date, time and position
+//
+// Comparisons can be combined with and, or, not and parentheses. Comparisons next to each other are and-ed.
+type Query struct {
+	root   queryNode
+	fields map[string]bool // every field used in the query, so we know what needs to be loaded
+}
+
+type queryNode interface {
+	Match(d Dose, options *DisplayOptions) bool
+}
+
+type queryAnd []queryNode
+type queryOr []queryNode
+type queryNot struct{ node queryNode }
+
+func (q queryAnd) Match(d Dose, options *DisplayOptions) bool {
+	for _, n := range q {
+		if !n.Match(d, options) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (q queryOr) Match(d Dose, options *DisplayOptions) bool {
+	for _, n := range q {
+		if n.Match(d, options) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (q queryNot) Match(d Dose, options *DisplayOptions) bool {
+	return !q.node.Match(d, options)
+}
+
+// queryFields maps every field name (and alias) to the name used internally
+var queryFields = map[string]string{
+	"drug":     "drug",
+	"roa":      "roa",
+	"dose":     "dose",
+	"dosage":   "dose",
+	"amount":   "dose",
+	"unit":     "unit",
+	"note":     "note",
+	"date":     "date",
+	"time":     "time",
+	"timezone": "timezone",
+	"tz":       "timezone",
+	"position": "position",
+	"pos":      "position",
+	"category": "category",
+	"class":    "class",
+}
+
+var queryOperators = []string{"!=", "!~", ">=", "<=", "=", "~", ":", ">", "<"}
+
+type queryComparison struct {
+	field string
+	op    string
+	value string
+
+	regex     *regexp.Regexp // set for ~ and !~
+	number    float64        // set for dose and position
+	unit      string         // set for dose
+	clock     time.Duration  // set for time, since midnight
+	precision time.Duration  // set for time, doses are truncated to this before comparing to clock
+}
+
+func (q *queryComparison) Match(d Dose, options *DisplayOptions) bool {
+	switch q.field {
+	case "dose":
+		return q.matchDose(d)
+	case "position":
+		return q.compare(float64(d.Position) - q.number)
+	case "date":
+		return q.compare(float64(strings.Compare(d.Date, q.value)))
+	case "time":
+		// Compare the parsed time, as doses can be logged with or without seconds
+		t, err := d.ParsedWallClock()
+		if err != nil {
+			return false
+		}
+
+		return q.compare(float64(t.Sub(t.Truncate(24*time.Hour)).Truncate(q.precision) - q.clock))
+	case "category":
+		return q.matchString(categories.Get(d))
+	case "class":
+		switch q.op {
+		case "=", ":":
+			return config.ClassMatches(q.value, d, options)
+		default:
+			return !config.ClassMatches(q.value, d, options)
+		}
+	default:
+		return q.matchString(q.stringField(d))
+	}
+}
+
+func (q *queryComparison) stringField(d Dose) string {
+	switch q.field {
+	case "drug":
+		return d.Drug
+	case "roa":
+		roa, _ := normalizeRoa(d.RoA)
+		return roa
+	case "unit":
+		quantity, _ := d.ParsedQuantity()
+		return quantity.Unit
+	case "note":
+		return d.Note
+	case "timezone":
+		return d.Timezone
+	default:
+		return ""
+	}
+}
+
+func (q *queryComparison) matchString(s string) bool {
+	switch q.op {
+	case "=":
+		return strings.EqualFold(s, q.value)
+	case "!=":
+		return !strings.EqualFold(s, q.value)
+	case "~":
+		return q.regex.MatchString(s)
+	case "!~":
+		return !q.regex.MatchString(s)
+	case ":":
+		return strings.Contains(strings.ToLower(s), strings.ToLower(q.value))
+	default:
+		return false
+	}
+}
+
+func (q *queryComparison) matchDose(d Dose) bool {
+	switch q.op {
+	case "~", "!~", ":":
+		return q.matchString(d.Dosage)
+	}
+
+	// A query without a unit compares the number of any dose
+	if q.unit == "" {
+		amount, _, err := d.Total()
+		return err == nil && q.compare(amount-q.number)
+	}
+
+	// Otherwise only compare doses that can be converted to the unit of the query, see Dose.AmountIn
+	amount, ok := d.AmountIn(lookupDrugUnit(d.Drug, q.unit))
+	return ok && q.compare(amount-q.number)
+}
+
+// compare returns the result of q.op, where diff is the result of subtracting the value from the field
+func (q *queryComparison) compare(diff float64) bool {
+	switch q.op {
+	case "=", ":":
+		return diff == 0
+	case "!=":
+		return diff != 0
+	case ">":
+		return diff > 0
+	case ">=":
+		return diff >= 0
+	case "<":
+		return diff < 0
+	case "<=":
+		return diff <= 0
+	default:
+		return false
+	}
+}
+
+// ParseQuery parses s, relative dates and times such as "today" or "-1h" are resolved from now
+func ParseQuery(s string, now time.Time) (*Query, error) {
+	p := &queryParser{s: []rune(s), now: now, fields: make(map[string]bool)}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected \"%s\"", string(p.s[p.pos:]))
+	}
+
+	return &Query{root: root, fields: p.fields}, nil
+}
+
+func (q *Query) Match(d Dose, options *DisplayOptions) bool {
+	return q.root.Match(d, options)
+}
+
+// Uses returns true if field is used anywhere in q
+func (q *Query) Uses(field string) bool {
+	return q != nil && q.fields[field]
+}
+
+type queryParser struct {
+	s      []rune
+	pos    int
+	now    time.Time
+	fields map[string]bool
+}
+
+func (p *queryParser) errorf(format string, a ...any) error {
+	return fmt.Errorf("column %v: %s", p.pos+1, fmt.Sprintf(format, a...))
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.s) && unicode.IsSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+// peekKeyword returns true and consumes the keyword if it is next in the query
+func (p *queryParser) peekKeyword(keyword string) bool {
+	p.skipSpace()
+
+	end := p.pos + len(keyword)
+	if end > len(p.s) || !strings.EqualFold(string(p.s[p.pos:end]), keyword) {
+		return false
+	}
+
+	// Make sure we aren't matching the start of a field name, eg "notes"
+	if end < len(p.s) && (unicode.IsLetter(p.s[end]) || unicode.IsDigit(p.s[end])) {
+		return false
+	}
+
+	p.pos = end
+	return true
+}
+
+func (p *queryParser) parseOr() (queryNode, error) {
+	nodes := make(queryOr, 0)
+
+	for {
+		n, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, n)
+
+		if !p.peekKeyword("or") {
+			break
+		}
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	return nodes, nil
+}
+
+func (p *queryParser) parseAnd() (queryNode, error) {
+	nodes := make(queryAnd, 0)
+
+	for {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		nodes = append(nodes, n)
+
+		// Comparisons next to each other are treated as "and"
+		and := p.peekKeyword("and")
+		if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] == ')' {
+			if and {
+				return nil, p.errorf("expected a comparison after \"and\"")
+			}
+
+			break
+		}
+
+		start := p.pos
+		if p.peekKeyword("or") {
+			if and {
+				return nil, p.errorf("expected a comparison between \"and\" and \"or\"")
+			}
+
+			p.pos = start
+			break
+		}
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+
+	return nodes, nil
+}
+
+func (p *queryParser) parseUnary() (queryNode, error) {
+	if p.peekKeyword("not") {
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		return queryNot{n}, nil
+	}
+
+	p.skipSpace()
+	if p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, p.errorf("expected \")\"")
+		}
+
+		p.pos++
+		return n, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *queryParser) parseComparison() (queryNode, error) {
+	p.skipSpace()
+
+	start := p.pos
+	for p.pos < len(p.s) && (unicode.IsLetter(p.s[p.pos]) || p.s[p.pos] == '_') {
+		p.pos++
+	}
+
+	name := strings.ToLower(string(p.s[start:p.pos]))
+	if name == "" {
+		if p.pos >= len(p.s) {
+			return nil, p.errorf("expected a field name, found end of query")
+		}
+
+		return nil, p.errorf("expected a field name, found \"%c\"", p.s[p.pos])
+	}
+
+	if name == "and" || name == "or" {
+		p.pos = start
+		return nil, p.errorf("expected a comparison before \"%s\"", name)
+	}
+
+	field, ok := queryFields[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown field \"%s\"", name)
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, o := range queryOperators {
+		if strings.HasPrefix(string(p.s[p.pos:]), o) {
+			op = o
+			p.pos += len(o)
+			break
+		}
+	}
+
+	if op == "" {
+		return nil, p.errorf("expected an operator after \"%s\", one of: %s", name, strings.Join(queryOperators, " "))
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	p.fields[field] = true
+	return newQueryComparison(field, op, value, p.now)
+}
+
+// parseValue reads a quoted string, or everything up to the next space or closing parenthesis
+func (p *queryParser) parseValue() (string, error) {
+	p.skipSpace()
+
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		p.pos++
+
+		var sb strings.Builder
+		for ; p.pos < len(p.s); p.pos++ {
+			switch c := p.s[p.pos]; {
+			case c == '\\' && p.pos+1 < len(p.s):
+				p.pos++
+				sb.WriteRune(p.s[p.pos])
+			case c == '"':
+				p.pos++
+				return sb.String(), nil
+			default:
+				sb.WriteRune(c)
+			}
+		}
+
+		return "", p.errorf("unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && !unicode.IsSpace(p.s[p.pos]) && p.s[p.pos] != ')' {
+		p.pos++
+	}
+
+	if start == p.pos {
+		return "", p.errorf("expected a value")
+	}
+
+	return string(p.s[start:p.pos]), nil
+}
+
+func newQueryComparison(field, op, value string, now time.Time) (*queryComparison, error) {
+	q := &queryComparison{field: field, op: op, value: value}
+
+	ordered := op == ">" || op == ">=" || op == "<" || op == "<="
+	switch field {
+	case "dose", "position", "date", "time":
+	default:
+		if ordered {
+			return nil, fmt.Errorf("operator \"%s\" can't be used with \"%s\"", op, field)
+		}
+	}
+
+	if op == "~" || op == "!~" {
+		r, err := regexp.Compile(fmt.Sprintf("(?i)%s", value))
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regex for \"%s\": %v", field, err)
+		}
+
+		q.regex = r
+	}
+
+	switch field {
+	case "dose":
+		if op == "~" || op == "!~" || op == ":" {
+			break
+		}
+
+		quantity, err := parseQuantity(value)
+		if err != nil || !isDosageUnit(quantity.Unit) || quantity.Max != 0 || quantity.Count != 0 || quantity.Concentration != nil {
+			return nil, fmt.Errorf("failed to parse dose \"%s\", expected something like 20mg", value)
+		}
+
+		q.number, q.unit = quantity.Value, quantity.Unit
+	case "position":
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse position \"%s\": %v", value, err)
+		}
+
+		q.number = float64(n)
+	case "date":
+		if op == "~" || op == "!~" || op == ":" {
+			return nil, fmt.Errorf("operator \"%s\" can't be used with \"%s\"", op, field)
+		}
+
+		ts, err := parseDate(value, now)
+		if err != nil {
+			return nil, err
+		}
+
+		q.value = ts.Format("2006/01/02")
+	case "time":
+		if op == "~" || op == "!~" || op == ":" {
+			return nil, fmt.Errorf("operator \"%s\" can't be used with \"%s\"", op, field)
+		}
+
+		ts, err := parseTime(value, now, now)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only compare seconds when they're part of the query, so that time=15:04 matches a dose at 15:04:30
+		q.clock = time.Duration(ts.Hour())*time.Hour + time.Duration(ts.Minute())*time.Minute + time.Duration(ts.Second())*time.Second
+		q.precision = time.Minute
+		if ts.Second() != 0 || strings.Count(value, ":") > 1 {
+			q.precision = time.Second
+		}
+	case "roa":
+		// Compare canonical names, so that roa=nasal matches Insufflated and doses logged as Snorted
+		if op == "=" || op == "!=" {
+			q.value, _ = normalizeRoa(value)
+		}
+	case "category", "class":
+		q.value = strings.ToLower(value)
+		if field != "class" {
+			break
+		}
+
+		if op != "=" && op != "!=" && op != ":" {
+			return nil, fmt.Errorf("operator \"%s\" can't be used with \"%s\"", op, field)
+		}
+
+		// config is always loaded before parsing -q
+		if err := (ClassFilter{{q.value}}).Validate(config); err != nil {
+			return nil, err
+		}
+	}
+
+	return q, nil
+}
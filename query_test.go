@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var queryNow = time.Date(2024, 3, 4, 15, 30, 0, 0, time.UTC)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{`drug=Caffeine`, false},
+		{`drug~"amph" and roa=insufflated and dose>20mg`, false},
+		{`drug=a drug=b`, false},
+		{`(drug=a or drug=b) and not roa=oral`, false},
+		{`notes:"work"`, true},
+		{`note:"unterminated`, true},
+		{`drug=a and or drug=b`, true},
+		{`drug=a or or drug=b`, true},
+		{`drug=a and and drug=b`, true},
+		{`and drug=a`, true},
+		{`drug=a and`, true},
+		{`drug=a or`, true},
+		{`(drug=a and) or drug=b`, true},
+		{`(drug=a`, true},
+		{`drug=a)`, true},
+		{`drug>a`, true},
+		{`dose>lots`, true},
+		{`date~2024`, true},
+		{`drug`, true},
+		{`drug=`, true},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseQuery(tt.query, queryNow); (err != nil) != tt.wantErr {
+			t.Errorf("ParseQuery(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQueryMatch(t *testing.T) {
+	dose := Dose{
+		Position: 12,
+		Date:     "2024/03/04",
+		Time:     "10:30",
+		Dosage:   "30mg",
+		Drug:     "Amphetamine",
+		RoA:      "Insufflated",
+		Note:     "before work",
+	}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{`drug=amphetamine`, true},
+		{`drug!=Amphetamine`, false},
+		{`drug~"^amph"`, true},
+		{`drug!~"^amph"`, false},
+		{`note:"WORK"`, true},
+		{`roa=insufflated`, true},
+		{`dose>20mg`, true},
+		{`dose>0.02g`, true},
+		{`dose<=29mg`, false},
+		{`dose=30mg`, true},
+		{`dose>20u`, false},
+		{`date>=2024-03-01`, true},
+		{`date<2024/03/04`, false},
+		{`date=today`, true},
+		{`date=yesterday`, false},
+		{`time>=10:00 and time<11:00`, true},
+		{`pos>12`, false},
+		{`pos>=12`, true},
+		{`drug=Caffeine or drug=Amphetamine`, true},
+		{`drug=Caffeine or drug=Amphetamine and roa=oral`, false},
+		{`(drug=Caffeine or drug=Amphetamine) and not roa=oral`, true},
+		{`drug=Amphetamine drug=Caffeine`, false},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query, queryNow)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error = %v", tt.query, err)
+			continue
+		}
+
+		if got := q.Match(dose, &DisplayOptions{}); got != tt.want {
+			t.Errorf("ParseQuery(%q).Match() = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestQueryMatchSeconds(t *testing.T) {
+	dose := Dose{Date: "2024/03/04", Time: "15:04:30", Dosage: "10mg", Drug: "Caffeine"}
+
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{`time=15:04`, true},
+		{`time<=15:04`, true},
+		{`time>=15:04`, true},
+		{`time<15:04`, false},
+		{`time>15:04`, false},
+		{`time=15:04:30`, true},
+		{`time=15:04:00`, false},
+		{`time>15:04:00`, true},
+		{`time<15:04:31`, true},
+		{`time=15:05`, false},
+		{`time>=-30m`, true},
+		{`time>=-20m`, false},
+	}
+
+	for _, tt := range tests {
+		q, err := ParseQuery(tt.query, queryNow)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error = %v", tt.query, err)
+			continue
+		}
+
+		if got := q.Match(dose, &DisplayOptions{}); got != tt.want {
+			t.Errorf("ParseQuery(%q).Match() = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
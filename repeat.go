@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TemplateDose is a single dose of a template in the config, every field except the drug is optional
+type TemplateDose struct {
+	Dosage   string `json:"dosage,omitempty"`
+	Drug     string `json:"drug"`
+	RoA      string `json:"roa,omitempty"`
+	Duration string `json:"duration,omitempty"`
+	Note     string `json:"note,omitempty"`
+	Solution string `json:"solution,omitempty"`
+}
+
+// TemplateNames returns the names of every template, sorted alphabetically
+func (c *Config) TemplateNames() []string {
+	names := make([]string, 0)
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Template returns the doses of the template name
+func (c *Config) Template(name string) ([]TemplateDose, error) {
+	for n, template := range c.Templates {
+		if strings.EqualFold(n, name) {
+			if len(template) == 0 {
+				return template, fmt.Errorf("template \"%s\" has no doses", name)
+			}
+
+			return template, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown template \"%s\", known templates: %s", name, strings.Join(c.TemplateNames(), ", "))
+}
+
+// Input returns t as a DoseInput, with any field set in flags taking priority
+func (t TemplateDose) Input(flags DoseInput) DoseInput {
+	in := DoseInput{
+		Dosage:   t.Dosage,
+		Drug:     t.Drug,
+		RoA:      t.RoA,
+		Duration: t.Duration,
+		Note:     t.Note,
+		Solution: t.Solution,
+	}
+
+	return in.Override(flags)
+}
+
+// repeatInput returns the last dose of drug, or the last dose if drug is empty, as a DoseInput.
+// The date, time and timezone are left empty, so that the repeated dose is taken now unless flags say otherwise.
+func repeatInput(doses []Dose, drug string) (DoseInput, error) {
+	for i := len(doses) - 1; i >= 0; i-- {
+		d := doses[i]
+		if drug != "" && !strings.EqualFold(d.Drug, drug) {
+			continue
+		}
+
+		return DoseInput{
+			Duration: d.Duration,
+			Dosage:   d.Dosage,
+			Drug:     d.Drug,
+			RoA:      d.RoA,
+			Note:     d.Note,
+			Solution: d.Solution,
+		}, nil
+	}
+
+	if drug != "" {
+		return DoseInput{}, fmt.Errorf("no doses of \"%s\" to repeat", drug)
+	}
+
+	return DoseInput{}, fmt.Errorf("no doses to repeat")
+}
+
+// Override returns in with every field that is set in flags replaced.
+// Setting -end replaces a repeated duration, as only one of them can be used.
+func (in DoseInput) Override(flags DoseInput) DoseInput {
+	fields := []struct {
+		field *string
+		value string
+	}{
+		{&in.Timezone, flags.Timezone},
+		{&in.Date, flags.Date},
+		{&in.Time, flags.Time},
+		{&in.End, flags.End},
+		{&in.Duration, flags.Duration},
+		{&in.Dosage, flags.Dosage},
+		{&in.Drug, flags.Drug},
+		{&in.RoA, flags.RoA},
+		{&in.Note, flags.Note},
+		{&in.Solution, flags.Solution},
+	}
+
+	for _, f := range fields {
+		if f.value != "" {
+			*f.field = f.value
+		}
+	}
+
+	if flags.End != "" && flags.Duration == "" {
+		in.Duration = ""
+	}
+
+	return in
+}
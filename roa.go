@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// roaAliases is every known RoA, and the other names it's commonly logged as.
+// More RoAs and aliases can be added with "roas" in the config.
+var roaAliases = []struct {
+	Name    string
+	Aliases []string
+}{
+	{"Oral", []string{"PO", "Swallowed"}},
+	{"Sublingual", []string{"SL"}},
+	{"Buccal", []string{"Gum"}},
+	{"Insufflated", []string{"Nasal", "Intranasal", "Snorted", "Sniffed", "Insufflation"}},
+	{"Inhaled", []string{"Inhalation"}},
+	{"Smoked", []string{}},
+	{"Vaporized", []string{"Vaporised", "Vaped", "Vape"}},
+	{"Rectal", []string{"Boofed", "Plugged", "PR"}},
+	{"Intravenous", []string{"IV", "Injected"}},
+	{"Intramuscular", []string{"IM"}},
+	{"Subcutaneous", []string{"SC", "SubQ", "Sub-Q"}},
+	{"Transdermal", []string{"Patch", "TD"}},
+	{"Topical", []string{}},
+}
+
+// roaNames returns the name of every known RoA, including RoAs from the config
+func roaNames() []string {
+	names := make([]string, 0)
+	for _, roa := range roaAliases {
+		names = append(names, roa.Name)
+	}
+
+	for name := range config.RoAs {
+		if _, ok := normalizeRoa(name); !ok {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// normalizeRoa returns the canonical name of roa, and false if it isn't a known RoA or alias
+func normalizeRoa(roa string) (string, bool) {
+	roa = strings.TrimSpace(roa)
+
+	for _, r := range roaAliases {
+		if strings.EqualFold(r.Name, roa) {
+			return r.Name, true
+		}
+
+		for _, alias := range r.Aliases {
+			if strings.EqualFold(alias, roa) {
+				return r.Name, true
+			}
+		}
+	}
+
+	for name, aliases := range config.RoAs {
+		if strings.EqualFold(name, roa) {
+			return name, true
+		}
+
+		for _, alias := range aliases {
+			if strings.EqualFold(alias, roa) {
+				return name, true
+			}
+		}
+	}
+
+	return roa, false
+}
+
+// validateRoa returns the canonical name of roa, or an error with the closest known RoA
+func validateRoa(roa string) (string, error) {
+	if name, ok := normalizeRoa(roa); ok {
+		return name, nil
+	}
+
+	names := roaNames()
+	if suggestion := closestName(roa, names, 3); suggestion != "" {
+		return roa, fmt.Errorf("unknown RoA \"%s\", did you mean \"%s\"? Use -force to add it anyway", roa, suggestion)
+	}
+
+	return roa, fmt.Errorf("unknown RoA \"%s\", known RoAs: %s (use -force to add it anyway)", roa, strings.Join(names, ", "))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Solution is a named solution from the config, so volumetric doses can be converted to the amount of drug.
+// For example "pregabalin-50": {"drug": "Pregabalin", "concentration": "50mg/mL"} turns 1.5mL into 75mg.
+type Solution struct {
+	Drug          string  `json:"drug"`
+	Concentration string  `json:"concentration"`     // eg "50mg/mL", "10mg/5mL" or "100mg/g"
+	Density       float64 `json:"density,omitempty"` // in g/mL, only needed to convert between mass and volume (default 1)
+	Created       string  `json:"created,omitempty"` // when the solution was made, eg "2024-03-01"
+
+	concentration Concentration // generated from Concentration
+}
+
+// SolutionNames returns the names of every solution, sorted alphabetically
+func (c *Config) SolutionNames() []string {
+	names := make([]string, 0)
+	for name := range c.Solutions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Solution returns the solution name, and its name as written in the config
+func (c *Config) Solution(name string) (*Solution, string, error) {
+	for n, s := range c.Solutions {
+		if strings.EqualFold(n, name) {
+			return s, n, nil
+		}
+	}
+
+	return nil, name, fmt.Errorf("unknown solution \"%s\", known solutions: %s", name, strings.Join(c.SolutionNames(), ", "))
+}
+
+// Convert returns the amount of drug in amount of s, eg 1.5mL → 75mg, or false if unit can't be converted
+func (s *Solution) Convert(amount float64, unit string) (float64, string, bool) {
+	c := s.concentration
+
+	density := s.Density
+	if density == 0 {
+		density = 1
+	}
+
+	from, to := lookupUnit(unit), lookupUnit(c.PerUnit)
+	gram, milliliter := lookupUnit("g"), lookupUnit("mL")
+
+	var per float64
+	switch {
+	case from.Family == to.Family:
+		converted, err := from.Convert(amount, to)
+		if err != nil {
+			return amount, unit, false
+		}
+
+		per = converted
+	case from.Family == UnitFamilyMass && to.Family == UnitFamilyVolume: // weighed out a solution that is measured by volume
+		grams, _ := from.Convert(amount, gram)
+		per, _ = milliliter.Convert(grams/density, to)
+	case from.Family == UnitFamilyVolume && to.Family == UnitFamilyMass: // measured out a solution that is measured by weight
+		milliliters, _ := from.Convert(amount, milliliter)
+		per, _ = gram.Convert(milliliters*density, to)
+	default:
+		return amount, unit, false
+	}
+
+	return per / c.PerValue * c.Value, c.Unit, true
+}
+
+// Total returns the amount of drug in d and its unit, using the solution of d if the dosage doesn't have a concentration
+func (d Dose) Total() (float64, string, error) {
+	quantity, err := d.ParsedQuantity()
+	if err != nil {
+		return 0, "", err
+	}
+
+	amount, unit := quantity.Total()
+	if d.Solution == "" || quantity.Concentration != nil {
+		return amount, unit, nil
+	}
+
+	s, _, err := config.Solution(d.Solution)
+	if err != nil {
+		return amount, unit, nil
+	}
+
+	amount, unit, _ = s.Convert(amount, unit)
+	return amount, unit, nil
+}
+
+// Volume returns the dosage of d in mL, or 0 if it isn't measured by volume
+func (d Dose) Volume() float64 {
+	quantity, err := d.ParsedQuantity()
+	if err != nil {
+		return 0
+	}
+
+	u := lookupUnit(quantity.Unit)
+	if u.Family != UnitFamilyVolume {
+		return 0
+	}
+
+	volume, _ := u.Convert(quantity.Amount(), lookupUnit("mL"))
+	return volume
+}
+
+// AmountIn returns the amount of drug in d converted to the unit to, or false if it can't be converted.
+// Mass and volume are converted with the density of the drug from the substance database.
+func (d Dose) AmountIn(to Unit) (float64, bool) {
+	amount, unit, err := d.Total()
+	if err != nil {
+		return 0, false
+	}
+
+	from := lookupDrugUnit(d.Drug, unit)
+	if converted, err := from.Convert(amount, to); err == nil {
+		return converted, true
+	}
+
+	density := 0.0
+	if substance := config.substances.Get(d.Drug); substance != nil {
+		density = substance.Density
+	}
+
+	gram, milliliter := lookupUnit("g"), lookupUnit("mL")
+	switch {
+	case density == 0:
+		return 0, false
+	case from.Family == UnitFamilyVolume && to.Family == UnitFamilyMass:
+		milliliters, _ := from.Convert(amount, milliliter)
+		converted, _ := gram.Convert(milliliters*density, to)
+		return converted, true
+	case from.Family == UnitFamilyMass && to.Family == UnitFamilyVolume:
+		grams, _ := from.Convert(amount, gram)
+		converted, _ := milliliter.Convert(grams/density, to)
+		return converted, true
+	}
+
+	return 0, false
+}
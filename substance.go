@@ -0,0 +1,232 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed substances.json
+var substancesBundled []byte
+
+// Substance is an entry in the substance database, which is bundled in substances.json and extended by the config.
+// Names, aliases and misspellings are all matched case-insensitively.
+// Aliases are replaced by Name when adding a dose, while misspellings are suggested by "did you mean" first.
+type Substance struct {
+	Name         string   `json:"name"`
+	Aliases      []string `json:"aliases,omitempty"`      // other names, eg brand names or abbreviations
+	Misspellings []string `json:"misspellings,omitempty"` // common typos, kept separate so they aren't suggested
+	Classes      []string `json:"classes,omitempty"`      // used by -class, the same as classes from the config
+	Density      float64  `json:"density,omitempty"`      // in g/mL, for liquids that are dosed by volume
+
+	// Bioavailability is the fraction of a dose that reaches the bloodstream for each RoA, eg {"Oral": 0.2} for Ketamine.
+	// These are rough estimates, and can be overridden for each RoA in the config.
+	Bioavailability map[string]float64 `json:"bioavailability,omitempty"`
+
+	// Used by -active to estimate how much of each dose is left, eg "5h", "45m" or "1d12h"
+	HalfLife string `json:"half_life,omitempty"` // elimination half-life, measured from the peak
+	Onset    string `json:"onset,omitempty"`     // until the drug starts being absorbed
+	Peak     string `json:"peak,omitempty"`      // until the peak concentration (default Onset)
+	Duration string `json:"duration,omitempty"`  // how long the effects last
+
+	halfLife, onset, peak, duration time.Duration // generated from HalfLife, Onset, Peak and Duration
+}
+
+// Substances is the substance database, indexed by every lowercase name, alias and misspelling
+type Substances map[string]*Substance
+
+// loadSubstances will load the bundled substances, and then extended.
+// A substance in extended with the same name as a bundled one will add to it, rather than replacing it.
+func loadSubstances(extended []Substance) (Substances, error) {
+	bundled := make([]Substance, 0)
+	if err := json.Unmarshal(substancesBundled, &bundled); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal bundled substances: %v", err)
+	}
+
+	s := make(Substances)
+	for _, substance := range append(bundled, extended...) {
+		if err := s.add(substance); err != nil {
+			return s, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s Substances) add(substance Substance) error {
+	if substance.Name == "" {
+		return fmt.Errorf("substance has no name: %v", substance)
+	}
+
+	existing, ok := s[strings.ToLower(substance.Name)]
+	if !ok || !strings.EqualFold(existing.Name, substance.Name) {
+		// An alias of another substance can be used as a name, eg to split Ethanol from Alcohol
+		existing = &Substance{Name: substance.Name}
+	}
+
+	existing.Aliases = append(existing.Aliases, substance.Aliases...)
+	existing.Misspellings = append(existing.Misspellings, substance.Misspellings...)
+	existing.Classes = append(existing.Classes, substance.Classes...)
+	if substance.Density != 0 {
+		existing.Density = substance.Density
+	}
+
+	for _, t := range []struct {
+		s     string
+		field *string
+		d     *time.Duration
+	}{
+		{substance.HalfLife, &existing.HalfLife, &existing.halfLife},
+		{substance.Onset, &existing.Onset, &existing.onset},
+		{substance.Peak, &existing.Peak, &existing.peak},
+		{substance.Duration, &existing.Duration, &existing.duration},
+	} {
+		if t.s == "" {
+			continue
+		}
+
+		d, err := parseDuration(t.s)
+		if err != nil {
+			return fmt.Errorf("failed to parse pharmacokinetics of %s: %v", substance.Name, err)
+		} else if d <= 0 {
+			return fmt.Errorf("pharmacokinetics of %s must be positive, not \"%s\"", substance.Name, t.s)
+		}
+
+		*t.field, *t.d = t.s, d
+	}
+
+	for roa, fraction := range substance.Bioavailability {
+		if fraction <= 0 || fraction > 1 {
+			return fmt.Errorf("bioavailability of %s by %s must be between 0 and 1, not %v", substance.Name, roa, fraction)
+		}
+
+		if existing.Bioavailability == nil {
+			existing.Bioavailability = make(map[string]float64)
+		}
+
+		name, _ := normalizeRoa(roa)
+		existing.Bioavailability[name] = fraction
+	}
+
+	for _, name := range append(append([]string{existing.Name}, existing.Aliases...), existing.Misspellings...) {
+		s[strings.ToLower(name)] = existing
+	}
+
+	return nil
+}
+
+// Get returns the substance for a name, alias or misspelling, or nil if it isn't known
+func (s Substances) Get(name string) *Substance {
+	return s[strings.ToLower(strings.TrimSpace(name))]
+}
+
+// IsMisspelling returns true if name is one of the misspellings of s, rather than its name or an alias
+func (s *Substance) IsMisspelling(name string) bool {
+	name = strings.TrimSpace(name)
+	if s == nil || strings.EqualFold(s.Name, name) {
+		return false
+	}
+
+	for _, alias := range s.Aliases {
+		if strings.EqualFold(alias, name) {
+			return false
+		}
+	}
+
+	return containsFold(s.Misspellings, name)
+}
+
+// sameDrug returns true if a and b are the same drug, either by name or as names of the same substance, eg Xanax and Alprazolam
+func sameDrug(a, b string) bool {
+	return drugKey(a) == drugKey(b)
+}
+
+// drugKey returns the lowercase name of the substance of drug, or drug itself in lowercase if it isn't known
+func drugKey(drug string) string {
+	if substance := config.substances.Get(drug); substance != nil {
+		return strings.ToLower(substance.Name)
+	}
+
+	return strings.ToLower(strings.TrimSpace(drug))
+}
+
+// Names returns every canonical name, sorted alphabetically
+func (s Substances) Names() []string {
+	names := make([]string, 0)
+	for key, substance := range s {
+		if strings.EqualFold(key, substance.Name) {
+			names = append(names, substance.Name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// HasClass returns true if class is a class of any substance
+func (s Substances) HasClass(class string) bool {
+	for _, substance := range s {
+		if substance.InClass(class) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClassRegex returns a regex matching every name, alias and misspelling of the substances in class, or "" if there are none
+func (s Substances) ClassRegex(class string) string {
+	names := make([]string, 0)
+	for key, substance := range s {
+		if substance.InClass(class) {
+			names = append(names, regexp.QuoteMeta(key))
+		}
+	}
+
+	// Longer names first, so that the whole name is matched when one contains another, eg Ket and Ketamine
+	sort.Slice(names, func(i, j int) bool {
+		if len(names[i]) != len(names[j]) {
+			return len(names[i]) > len(names[j])
+		}
+
+		return names[i] < names[j]
+	})
+
+	return strings.Join(names, "|")
+}
+
+// InClass returns true if the substance is in class
+func (s *Substance) InClass(class string) bool {
+	for _, c := range s.Classes {
+		if strings.EqualFold(c, class) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AvailableFraction returns the fraction of a dose by roa that reaches the bloodstream, or false if it isn't known.
+// Intravenous doses are always fully bioavailable.
+func (s *Substance) AvailableFraction(roa string) (float64, bool) {
+	name, _ := normalizeRoa(roa)
+	if name == "Intravenous" {
+		return 1, true
+	}
+
+	if s == nil || name == "" {
+		return 0, false
+	}
+
+	for r, fraction := range s.Bioavailability {
+		if strings.EqualFold(r, name) {
+			return fraction, true
+		}
+	}
+
+	return 0, false
+}
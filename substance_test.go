@@ -0,0 +1,87 @@
+package main
+
+import "testing"
+
+func loadTestSubstances(t *testing.T) {
+	s, err := loadSubstances(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := config
+	config = &Config{substances: s}
+	t.Cleanup(func() { config = previous })
+}
+
+func TestCanonicalDrug(t *testing.T) {
+	loadTestSubstances(t)
+	doses := []Dose{{Drug: "Cafeine"}, {Drug: "lions mane"}}
+
+	tests := []struct {
+		drug string
+		want string
+	}{
+		{"alprazolam", "Alprazolam"},
+		{"Xanax", "Alprazolam"},
+		{" xanax ", "Alprazolam"},
+		{"Cafeine", "Cafeine"},
+		{"Caffiene", "Caffiene"},
+		{"Lions Mane", "lions mane"},
+		{"new drug", "New Drug"},
+	}
+
+	for _, tt := range tests {
+		if got := canonicalDrug(doses, tt.drug); got != tt.want {
+			t.Errorf("canonicalDrug(%q) = %q, want %q", tt.drug, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDrugTypo(t *testing.T) {
+	loadTestSubstances(t)
+	doses := []Dose{{Drug: "Cafeine"}, {Drug: "lions mane"}}
+
+	tests := []struct {
+		drug       string
+		suggestion string
+	}{
+		{"Alprazolam", ""},
+		{"Xanax", ""},
+		{"Cafeine", ""},
+		{"Caffiene", "Caffeine"},
+		{"Ketamin", "Ketamine"},
+		{"lions man", "lions mane"},
+		{"Something else entirely", ""},
+	}
+
+	for _, tt := range tests {
+		err := checkDrugTypo(doses, tt.drug)
+		if tt.suggestion == "" && err != nil {
+			t.Errorf("checkDrugTypo(%q) error = %v, want nil", tt.drug, err)
+		} else if typo, ok := err.(*DrugTypoError); tt.suggestion != "" && (!ok || typo.Suggestion != tt.suggestion) {
+			t.Errorf("checkDrugTypo(%q) error = %v, want a suggestion of %q", tt.drug, err, tt.suggestion)
+		}
+	}
+}
+
+func TestSameDrug(t *testing.T) {
+	loadTestSubstances(t)
+
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"Alprazolam", "alprazolam", true},
+		{"Xanax", "Alprazolam", true},
+		{"Caffiene", "Caffeine", true},
+		{"Alprazolam", "Diazepam", false},
+		{"Foo", "foo ", true},
+		{"Foo", "Bar", false},
+	}
+
+	for _, tt := range tests {
+		if got := sameDrug(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameDrug(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
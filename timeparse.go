@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// dateLayout is used for `-date`, using 00:00 as the suffix
+	dateLayout = TimestampLayout{
+		[]LayoutFormat{"2006/01/02", "2006-01-02", "01/02/2006", "01-02-2006", "20060102", "01-02", "0102", "02"},
+		WrapFormat{Suffix: "1504"}, WrapFormat{Suffix: "0000"},
+	}
+
+	// timeLayout is used for `-time`, Value.Prefix must be set to the date in "20060102" format
+	timeLayout = TimestampLayout{
+		[]LayoutFormat{"3:04pm", "03:04pm", "15:04", "3:04", "1504", "3pm", "03pm", "3:04:05pm", "03:04:05pm", "15:04:05", "150405"},
+		WrapFormat{Prefix: "20060102"}, WrapFormat{},
+	}
+
+	// timestampLayout is used for ISO 8601 / RFC 3339 timestamps in `-date`, see parseTimestamp
+	timestampLayout = TimestampLayout{
+		[]LayoutFormat{
+			"2006-01-02T15:04:05-07:00", "2006-01-02T15:04:05-0700", "2006-01-02T15:04-07:00",
+			"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04",
+		},
+		WrapFormat{}, WrapFormat{},
+	}
+
+	durationRegex      = regexp.MustCompile(`(\d+(?:\.\d+)?)(w|d|h|m|s)`)
+	fractionalSecRegex = regexp.MustCompile(`(T\d{2}:\d{2}:\d{2})\.\d+`)
+	unixTimestampRegex = regexp.MustCompile(`^@?(\d{9,})$`)
+	weekdays           = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+)
+
+// parseDuration is the same as time.ParseDuration, but also supports days (d) and weeks (w), eg 1d12h
+func parseDuration(p string) (time.Duration, error) {
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(p, "-"):
+		sign, p = -1, p[1:]
+	case strings.HasPrefix(p, "+"):
+		p = p[1:]
+	}
+
+	matches := durationRegex.FindAllStringSubmatchIndex(p, -1)
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("failed to parse duration \"%s\", expected something like 45m, 1h30m or 2d", p)
+	}
+
+	d, end := time.Duration(0), 0
+	for _, m := range matches {
+		if m[0] != end {
+			return 0, fmt.Errorf("failed to parse duration \"%s\", unexpected \"%s\"", p, p[end:m[0]])
+		}
+
+		n, err := strconv.ParseFloat(p[m[2]:m[3]], 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse duration \"%s\": %v", p, err)
+		}
+
+		unit := map[string]time.Duration{"w": 7 * 24 * time.Hour, "d": 24 * time.Hour, "h": time.Hour, "m": time.Minute, "s": time.Second}[p[m[4]:m[5]]]
+		d += time.Duration(n * float64(unit))
+		end = m[1]
+	}
+
+	if end != len(p) {
+		return 0, fmt.Errorf("failed to parse duration \"%s\", unexpected \"%s\"", p, p[end:])
+	}
+
+	return sign * d, nil
+}
+
+// formatDuration formats d like time.Duration.String, without any trailing zero units, eg 1h30m instead of 1h30m0s
+func formatDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+
+	s := d.Round(time.Second).String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+
+	return s
+}
+
+// formatDurationDays is the same as formatDuration, but with days for durations longer than a day, eg 3d4h instead of 76h
+func formatDurationDays(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	if days == 0 {
+		return formatDuration(d)
+	}
+
+	return fmt.Sprintf("%vd", int64(days)) + formatDuration((d - days*24*time.Hour).Truncate(time.Hour))
+}
+
+// formatClock formats the time of t for Dose.Time, only including seconds if they're set
+func formatClock(t time.Time) string {
+	if t.Second() != 0 {
+		return t.Format("15:04:05")
+	}
+
+	return t.Format("15:04")
+}
+
+// formatNearTime formats t in the timezone of now, and only includes the date if it isn't the same day
+func formatNearTime(t, now time.Time) string {
+	t = t.In(now.Location())
+
+	if y1, m1, d1 := t.Date(); y1 == now.Year() && m1 == now.Month() && d1 == now.Day() {
+		return t.Format("15:04")
+	}
+
+	return t.Format("2006/01/02 15:04")
+}
+
+// truncateRelative will truncate t to the minute, unless the relative duration d was given with seconds.
+// This keeps `-ago 45m` at the same precision as an unset `-time`.
+func truncateRelative(t time.Time, d time.Duration) time.Time {
+	if d%time.Minute != 0 {
+		return t.Truncate(time.Second)
+	}
+
+	return t.Truncate(time.Minute)
+}
+
+// isRelativeTime returns true if p is a relative `-time`, eg -1h30m or +15m
+func isRelativeTime(p string) bool {
+	return strings.HasPrefix(p, "-") || strings.HasPrefix(p, "+")
+}
+
+// parseTimestamp will parse p as an ISO 8601 / RFC 3339 timestamp or a unix timestamp (with an optional @ prefix).
+// ok is false if p doesn't look like a timestamp, so that it can be parsed as a date instead.
+func parseTimestamp(p string, loc *time.Location) (ts time.Time, ok bool, err error) {
+	// Unix timestamps are converted to RFC 3339, so that they're parsed the same way as every other timestamp
+	if m := unixTimestampRegex.FindStringSubmatch(p); m != nil {
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return ts, true, fmt.Errorf("failed to parse unix timestamp \"%s\": %v", p, err)
+		}
+
+		// Assume milliseconds for anything that would be past the year 5000 in seconds
+		unix := time.Unix(n, 0)
+		if len(m[1]) >= 13 {
+			unix = time.UnixMilli(n)
+		}
+
+		p = unix.UTC().Format(time.RFC3339Nano)
+	}
+
+	if !strings.ContainsAny(p, "T ") || len(p) < len("2006-01-02T15:04") {
+		return ts, false, nil
+	}
+
+	// Normalize the variable length parts of RFC 3339, so that it can be parsed by the fixed length layouts
+	p = fractionalSecRegex.ReplaceAllString(p, "$1")
+	if strings.HasSuffix(p, "Z") {
+		p = strings.TrimSuffix(p, "Z") + "+00:00"
+	}
+
+	t, err := parseTimestampLayout(p, &timestampLayout, loc)
+	if err != nil {
+		return ts, true, err
+	}
+
+	return t.In(loc), true, nil
+}
+
+// parseTimestampLayout will try to parse p using every format in l, in the location of loc
+func parseTimestampLayout(p string, l *TimestampLayout, loc *time.Location) (*time.Time, error) {
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	for _, f := range l.Formats {
+		// faster than waiting for time.ParseInLocation to fail
+		if len(p) != len(f) {
+			continue
+		}
+
+		if ts, err := time.ParseInLocation(
+			fmt.Sprintf("%s%s%s", l.Layout.Prefix, f, l.Layout.Suffix),
+			fmt.Sprintf("%s%s%s", l.Value.Prefix, p, l.Value.Suffix),
+			loc,
+		); err == nil {
+			return &ts, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to parse \"%s\" using layouts: %s", p, l.FormatsString())
+}
+
+// FormatsString returns every format of l, eg "[2006/01/02, 2006-01-02]", formats can contain spaces
+func (l *TimestampLayout) FormatsString() string {
+	formats := make([]string, 0, len(l.Formats))
+	for _, f := range l.Formats {
+		formats = append(formats, string(f))
+	}
+
+	return "[" + strings.Join(formats, ", ") + "]"
+}
+
+// parseDate will parse p using dateLayout, filling in the year and month from now for short dates.
+// today, yesterday and weekdays (mon, monday) are also supported, weekdays are the most recent one including today.
+// The returned time is at 00:00 in the location of now.
+func parseDate(p string, now time.Time) (time.Time, error) {
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch lower := strings.ToLower(p); lower {
+	case "today":
+		return day, nil
+	case "yesterday":
+		return day.AddDate(0, 0, -1), nil
+	default:
+		for n, weekday := range weekdays {
+			if len(lower) >= 3 && strings.HasPrefix(weekday, lower) {
+				return day.AddDate(0, 0, -((int(now.Weekday()) - n + 7) % 7)), nil
+			}
+		}
+	}
+
+	original := p
+	switch len(p) {
+	case 5: // 01-02 → 2006-01-02
+		p = now.Format("2006-") + p
+	case 4: // 0102  → 20060102
+		p = now.Format("2006") + p
+	case 2: // 02    → 2006/01/02
+		p = now.Format("2006/01/") + p
+	case 0: // unset → 2006/01/02 (first in LayoutFormat, so it parses the fastest)
+		p = now.Format("2006/01/02")
+	}
+
+	ts, err := parseTimestampLayout(p, &dateLayout, now.Location())
+	if err != nil {
+		return now, fmt.Errorf(
+			"failed to parse \"%s\" using layouts: %s, or today, yesterday, a weekday (mon), an ISO 8601 timestamp or a unix timestamp (@1709300000)",
+			original, dateLayout.FormatsString(),
+		)
+	}
+
+	return *ts, nil
+}
+
+// parseTime will parse p using timeLayout on the date of day, using the time of now if p is unset.
+// p can also be relative to the time of now, eg -1h30m.
+func parseTime(p string, day, now time.Time) (time.Time, error) {
+	if p == "" || p == "now" {
+		p = now.Format("1504") // only one matching len == 4, so it parses the fastest
+	}
+
+	if isRelativeTime(p) {
+		d, err := parseDuration(p)
+		if err != nil {
+			return day, err
+		}
+
+		return truncateRelative(time.Date(day.Year(), day.Month(), day.Day(), now.Hour(), now.Minute(), now.Second(), 0, day.Location()).Add(d), d), nil
+	}
+
+	l := timeLayout
+	l.Value.Prefix = day.Format("20060102")
+
+	ts, err := parseTimestampLayout(p, &l, day.Location())
+	if err != nil {
+		return day, fmt.Errorf("%v, or a relative time (-1h30m)", err)
+	}
+
+	return *ts, nil
+}
+
+var relativeDurationRegex = regexp.MustCompile(`^(\d+)\s*(min|m|h|d|w|mo|y)$`)
+
+// parseTimeRange will parse p as a range of time, relative to now. The end of the range is exclusive.
+// Absolute dates use the same layouts as -date, and cover the whole day.
+// Relative ranges can be durations (30m, 12h, 7d, 2w, 3mo, 1y) which end at now, or
+// today, yesterday, this / last week, this / last month, this / last year.
+// Months can be given as 2024-03 or March 2024, and years as 2024.
+func parseTimeRange(p string, now time.Time) (start time.Time, end time.Time, err error) {
+	p = strings.ToLower(strings.TrimSpace(p))
+	loc := now.Location()
+
+	// Timestamps that fail to parse can still be a date and a time, eg 2024-03-01 3:04pm
+	ts, ok, tsErr := parseTimestamp(strings.ToUpper(p), loc)
+	if ok && tsErr == nil {
+		return ts, ts, nil
+	}
+
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	year := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, loc)
+	week := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7)) // weeks start on monday
+
+	if m := relativeDurationRegex.FindStringSubmatch(p); m != nil {
+		n, _ := strconv.Atoi(m[1])
+
+		switch m[2] {
+		case "min", "m":
+			return now.Add(-time.Duration(n) * time.Minute), now, nil
+		case "h":
+			return now.Add(-time.Duration(n) * time.Hour), now, nil
+		case "d":
+			return now.AddDate(0, 0, -n), now, nil
+		case "w":
+			return now.AddDate(0, 0, -7*n), now, nil
+		case "mo":
+			return now.AddDate(0, -n, 0), now, nil
+		case "y":
+			return now.AddDate(-n, 0, 0), now, nil
+		}
+	}
+
+	switch p {
+	case "now":
+		return now, now, nil
+	case "today":
+		return day, day.AddDate(0, 0, 1), nil
+	case "yesterday":
+		return day.AddDate(0, 0, -1), day, nil
+	case "this week":
+		return week, week.AddDate(0, 0, 7), nil
+	case "last week":
+		return week.AddDate(0, 0, -7), week, nil
+	case "this month":
+		return month, month.AddDate(0, 1, 0), nil
+	case "last month":
+		return month.AddDate(0, -1, 0), month, nil
+	case "this year":
+		return year, year.AddDate(1, 0, 0), nil
+	case "last year":
+		return year.AddDate(-1, 0, 0), year, nil
+	}
+
+	// Months and years, eg 2024-03, March 2024, Mar 2024 and 2024
+	for _, layout := range []string{"2006-01", "2006/01", "January 2006", "Jan 2006", "January", "Jan"} {
+		if ts, err := time.ParseInLocation(layout, caser.String(p), loc); err == nil {
+			if !strings.Contains(layout, "2006") {
+				ts = ts.AddDate(now.Year(), 0, 0)
+			}
+
+			return ts, ts.AddDate(0, 1, 0), nil
+		}
+	}
+
+	// A date and a time, eg 2024-03-01 15:04
+	if fields := strings.Fields(p); len(fields) == 2 {
+		if ts, err := parseDate(fields[0], now); err == nil {
+			if ts, err := parseTime(fields[1], ts, now); err == nil {
+				return ts, ts, nil
+			}
+		}
+	}
+
+	ts, err = parseDate(p, now)
+	if err == nil {
+		return ts, ts.AddDate(0, 0, 1), nil
+	}
+
+	// Years are checked last, as 4 digits would otherwise be a valid date in the 0102 layout
+	if ts, yErr := time.ParseInLocation("2006", p, loc); yErr == nil {
+		return ts, ts.AddDate(1, 0, 0), nil
+	}
+
+	if tsErr != nil {
+		return now, now, tsErr
+	}
+
+	return now, now, err
+}
+
+// parseDateTime will parse -date and -time style input in loc, defaulting to the current date / time in loc.
+// Times in a DST gap or that are ambiguous are resolved using -fold, see parseWallClock to handle them yourself.
+func parseDateTime(date, clock string, loc *time.Location) (time.Time, error) {
+	wall, err := parseWallClock(date, clock, loc)
+	if err != nil {
+		return wall, err
+	}
+
+	return resolveWallClock(wall, loc).Pick(options.Fold), nil
+}
+
+// parseWallClock is the same as parseDateTime, but returns the literal wall-clock time in UTC.
+// time.ParseInLocation silently normalizes times that don't exist in loc, so we parse in UTC and resolve it afterwards.
+func parseWallClock(date, clock string, loc *time.Location) (time.Time, error) {
+	toWall := func(t time.Time) time.Time {
+		t = t.In(loc)
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), time.UTC)
+	}
+
+	now := toWall(time.Now())
+
+	// -date can also be a full timestamp, which is converted to loc
+	if ts, ok, err := parseTimestamp(date, loc); ok {
+		if err != nil {
+			return now, err
+		}
+
+		if clock != "" {
+			return now, fmt.Errorf("-time can't be used when -date is a timestamp (\"%s\")", date)
+		}
+
+		return toWall(ts), nil
+	}
+
+	// Relative times for today are calculated from the actual current time, so that DST transitions are accounted for
+	if date == "" && isRelativeTime(clock) {
+		d, err := parseDuration(clock)
+		if err != nil {
+			return now, err
+		}
+
+		return toWall(truncateRelative(time.Now().Add(d), d)), nil
+	}
+
+	t, err := parseDate(date, now)
+	if err != nil {
+		return t, err
+	}
+
+	return parseTime(clock, t, now)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		p       string
+		want    time.Time
+		ok      bool
+		wantErr bool
+	}{
+		{"2024-03-01T15:04", time.Date(2024, 3, 1, 15, 4, 0, 0, berlin), true, false},
+		{"2024-03-01 15:04:05", time.Date(2024, 3, 1, 15, 4, 5, 0, berlin), true, false},
+		{"2024-03-01T15:04:05Z", time.Date(2024, 3, 1, 16, 4, 5, 0, berlin), true, false},
+		{"2024-03-01T15:04:05.123Z", time.Date(2024, 3, 1, 16, 4, 5, 0, berlin), true, false},
+		{"2024-03-01T15:04:05+05:00", time.Date(2024, 3, 1, 11, 4, 5, 0, berlin), true, false},
+		{"2024-03-01T15:04:05-0700", time.Date(2024, 3, 1, 23, 4, 5, 0, berlin), true, false},
+		{"1709305440", time.Date(2024, 3, 1, 16, 4, 0, 0, berlin), true, false},
+		{"@1709305440", time.Date(2024, 3, 1, 16, 4, 0, 0, berlin), true, false},
+		{"1709305440000", time.Date(2024, 3, 1, 16, 4, 0, 0, berlin), true, false},
+		{"2024-03-01T25:04", time.Time{}, true, true},
+		{"2024-03-01", time.Time{}, false, false},
+		{"15:04", time.Time{}, false, false},
+		{"yesterday", time.Time{}, false, false},
+	}
+
+	for _, tt := range tests {
+		got, ok, err := parseTimestamp(tt.p, berlin)
+		if ok != tt.ok || (err != nil) != tt.wantErr {
+			t.Errorf("parseTimestamp(%q) ok = %v, error = %v, want ok %v, wantErr %v", tt.p, ok, err, tt.ok, tt.wantErr)
+			continue
+		}
+
+		if ok && err == nil && !got.Equal(tt.want) {
+			t.Errorf("parseTimestamp(%q) = %v, want %v", tt.p, got, tt.want)
+		}
+
+		if ok && err == nil && got.Location() != berlin {
+			t.Errorf("parseTimestamp(%q) location = %v, want %v", tt.p, got.Location(), berlin)
+		}
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	loc := time.UTC
+	now := time.Date(2024, 3, 6, 15, 30, 0, 0, loc) // a wednesday
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, loc) }
+
+	tests := []struct {
+		p       string
+		start   time.Time
+		end     time.Time
+		wantErr bool
+	}{
+		{"now", now, now, false},
+		{"today", day(2024, 3, 6), day(2024, 3, 7), false},
+		{"Yesterday", day(2024, 3, 5), day(2024, 3, 6), false},
+		{"this week", day(2024, 3, 4), day(2024, 3, 11), false},
+		{"last week", day(2024, 2, 26), day(2024, 3, 4), false},
+		{"last month", day(2024, 2, 1), day(2024, 3, 1), false},
+		{"this year", day(2024, 1, 1), day(2025, 1, 1), false},
+		{"12h", now.Add(-12 * time.Hour), now, false},
+		{"7d", day(2024, 2, 28).Add(15*time.Hour + 30*time.Minute), now, false},
+		{"3mo", time.Date(2023, 12, 6, 15, 30, 0, 0, loc), now, false},
+		{"2024-02", day(2024, 2, 1), day(2024, 3, 1), false},
+		{"March 2023", day(2023, 3, 1), day(2023, 4, 1), false},
+		{"2023", day(2023, 1, 1), day(2024, 1, 1), false},
+		{"2024-03-01", day(2024, 3, 1), day(2024, 3, 2), false},
+		{"03-01", day(2024, 3, 1), day(2024, 3, 2), false},
+		{"2024-03-01 15:04", time.Date(2024, 3, 1, 15, 4, 0, 0, loc), time.Date(2024, 3, 1, 15, 4, 0, 0, loc), false},
+		{"2024-03-01 3:04pm", time.Date(2024, 3, 1, 15, 4, 0, 0, loc), time.Date(2024, 3, 1, 15, 4, 0, 0, loc), false},
+		{"2024-03-01T15:04:05Z", time.Date(2024, 3, 1, 15, 4, 5, 0, loc), time.Date(2024, 3, 1, 15, 4, 5, 0, loc), false},
+		{"@1709305440", time.Date(2024, 3, 1, 15, 4, 0, 0, loc), time.Date(2024, 3, 1, 15, 4, 0, 0, loc), false},
+		{"2024-03-01 25:99", time.Time{}, time.Time{}, true},
+		{"2024-13-45", time.Time{}, time.Time{}, true},
+		{"soon", time.Time{}, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		start, end, err := parseTimeRange(tt.p, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeRange(%q) error = %v, wantErr %v", tt.p, err, tt.wantErr)
+			continue
+		}
+
+		if err == nil && (!start.Equal(tt.start) || !end.Equal(tt.end)) {
+			t.Errorf("parseTimeRange(%q) = %v - %v, want %v - %v", tt.p, start, end, tt.start, tt.end)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/thlib/go-timezone-local/tzlocal"
+)
+
+var timezones = make(TimezoneTimeline, 0)
+
+// TimezoneEntry records that the user was in Timezone from From, until the next entry
+type TimezoneEntry struct {
+	From     time.Time `json:"from"`
+	Timezone string    `json:"timezone"`
+}
+
+// TimezoneTimeline is stored in doses-timezones.json next to doses.json, and is always sorted by From
+type TimezoneTimeline []TimezoneEntry
+
+// timezonesUrl returns the URL for doses-timezones.json, relative to a doses.json URL
+func timezonesUrl(u string) string {
+	return strings.TrimSuffix(u, ".json") + "-timezones.json"
+}
+
+func loadTimezones(path string) (TimezoneTimeline, error) {
+	t := make(TimezoneTimeline, 0)
+
+	b, err := readFileOrUrl(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return t, nil
+		}
+
+		return t, err
+	}
+
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("failed to unmarshal timezones \"%s\": %v", path, err)
+	}
+
+	t.Sort()
+	return t, nil
+}
+
+func saveTimezones(t TimezoneTimeline) bool {
+	j, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		fmt.Printf("Failed to format timezones: %v\n", err)
+		return false
+	}
+
+	ok, u := saveFile(string(j)+"\n", timezonesUrl(options.SaveUrl))
+	if !ok {
+		fmt.Printf("`%s`: failed to save timezones file!\n", options.Mode)
+	} else {
+		fmt.Printf("`%s`: saved files:\n- %s\n", options.Mode, u)
+	}
+
+	return ok
+}
+
+func (t TimezoneTimeline) Sort() {
+	sort.SliceStable(t, func(i, j int) bool {
+		return t[i].From.Before(t[j].From)
+	})
+}
+
+// Add will add e to t, replacing any entry that starts at the same time
+func (t TimezoneTimeline) Add(e TimezoneEntry) TimezoneTimeline {
+	for n, existing := range t {
+		if existing.From.Equal(e.From) {
+			t[n] = e
+			return t
+		}
+	}
+
+	t = append(t, e)
+	t.Sort()
+	return t
+}
+
+// Lookup returns the timezone the user was in at a wall-clock date and time.
+// As the same wall-clock time is a different instant in every timezone, parse is called with the location of each entry,
+// and the newest entry that started before the parsed time is used.
+func (t TimezoneTimeline) Lookup(parse func(loc *time.Location) (time.Time, error)) (string, bool) {
+	for i := len(t) - 1; i >= 0; i-- {
+		loc, err := time.LoadLocation(t[i].Timezone)
+		if err != nil {
+			continue
+		}
+
+		if ts, err := parse(loc); err == nil && !ts.Before(t[i].From) {
+			return t[i].Timezone, true
+		}
+	}
+
+	return "", false
+}
+
+func (t TimezoneTimeline) String() string {
+	lines := ""
+	for n, e := range t {
+		until := "now"
+		if n+1 < len(t) {
+			until = t[n+1].From.In(e.From.Location()).Format("2006/01/02 15:04")
+		}
+
+		lines += fmt.Sprintf("%s - %s %s\n", e.From.Format("2006/01/02 15:04"), until, e.Timezone)
+	}
+
+	return lines
+}
+
+// resolveTimezone returns the timezone to use for a dose at date and clock when -timezone isn't set.
+// Every mode that adds doses uses it through newDose, including -add-batch imports, -repeat and -template.
+// The timezone timeline is used first, falling back to the timezone of the most chronologically recent dose.
+func resolveTimezone(doses []Dose, date, clock string) (string, error) {
+	if tz, ok := timezones.Lookup(func(loc *time.Location) (time.Time, error) {
+		return parseDateTime(date, clock, loc)
+	}); ok {
+		return tz, nil
+	}
+
+	if len(doses) > 0 {
+		return doses[len(doses)-1].Timezone, nil
+	}
+
+	return "", errors.New("`-timezone` is not set and no doses with a timezone were found! You must set a timezone to add doses first")
+}
+
+// currentTime returns time.Now() in -timezone, or the timezone from resolveTimezone, so reports match the logged times
+func currentTime(doses []Dose) time.Time {
+	now := time.Now()
+
+	tz := options.Timezone
+	if tz == "" {
+		tz, _ = resolveTimezone(doses, "", "")
+	}
+
+	if loc, err := time.LoadLocation(tz); err == nil && tz != "" {
+		now = now.In(loc)
+	}
+
+	return now
+}
+
+// warnSystemTimezone will print a warning if the system timezone has a different UTC offset to the logged timezone at t.
+// This usually means that the user has travelled and hasn't updated the timezone timeline with -tz-from.
+// The system timezone only tells us where the user is right now, so doses that aren't recent are ignored.
+func warnSystemTimezone(t time.Time) {
+	if time.Since(t).Abs() > 12*time.Hour {
+		return
+	}
+
+	locTZ, err := tzlocal.RuntimeTZ()
+	if err != nil {
+		return
+	}
+
+	locSystem, err := time.LoadLocation(locTZ)
+	if err != nil {
+		return
+	}
+
+	_, offset := t.Zone()
+	_, offsetSystem := t.In(locSystem).Zone()
+
+	if offset != offsetSystem {
+		fmt.Printf(
+			"Warning: logging in %s (%s) but the system timezone is %s (%s), use `-tz-from %s` if you have travelled\n",
+			t.Location(), t.Format("-07:00"), locTZ, t.In(locSystem).Format("-07:00"), locTZ,
+		)
+	}
+}
+
+// Fold is used to pick between the two possible times when a wall-clock time is ambiguous or in a DST gap, see -fold
+type Fold int64
+
+const (
+	FoldDefault Fold = iota // later for gaps, earlier for ambiguous times
+	FoldEarlier
+	FoldLater
+)
+
+func ParseFold(s string) (Fold, error) {
+	switch strings.ToLower(s) {
+	case "":
+		return FoldDefault, nil
+	case "earlier":
+		return FoldEarlier, nil
+	case "later":
+		return FoldLater, nil
+	default:
+		return FoldDefault, fmt.Errorf("\"%s\" is not a valid fold, must be \"earlier\" or \"later\"", s)
+	}
+}
+
+func (f Fold) String() string {
+	switch f {
+	case FoldEarlier:
+		return "earlier"
+	case FoldLater:
+		return "later"
+	default:
+		return ""
+	}
+}
+
+type WallClockKind int64
+
+const (
+	WallClockNormal    WallClockKind = iota
+	WallClockGap                     // skipped when the clocks go forward, eg 02:30 in Europe/Berlin on the last Sunday of March
+	WallClockAmbiguous               // happens twice when the clocks go back, eg 02:30 in Europe/Berlin on the last Sunday of October
+)
+
+// WallClock is every possible instant for a wall-clock time in a location.
+// For normal times Earlier and Later are the same.
+type WallClock struct {
+	Kind    WallClockKind
+	Wall    time.Time // the literal wall-clock time, in UTC
+	Earlier time.Time
+	Later   time.Time
+}
+
+// resolveWallClock will find every instant that wall (a literal wall-clock time in UTC) could be in loc
+func resolveWallClock(wall time.Time, loc *time.Location) WallClock {
+	t := time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), wall.Nanosecond(), loc)
+
+	// DST transitions are never less than a day apart, so the offsets a day before and after are the only candidates
+	_, offsetBefore := t.Add(-24 * time.Hour).Zone()
+	_, offsetAfter := t.Add(24 * time.Hour).Zone()
+
+	candidates := make([]time.Time, 0)
+	valid := make([]time.Time, 0)
+
+	for _, offset := range []int{offsetBefore, offsetAfter} {
+		c := wall.Add(-time.Duration(offset) * time.Second).In(loc)
+		candidates = append(candidates, c)
+
+		// The wall-clock time of c is only the same as wall if loc actually uses this offset at c
+		if _, o := c.Zone(); o == offset && (len(valid) == 0 || !valid[0].Equal(c)) {
+			valid = append(valid, c)
+		}
+	}
+
+	w := WallClock{Kind: WallClockNormal, Wall: wall}
+
+	switch len(valid) {
+	case 0:
+		w.Kind = WallClockGap
+		valid = candidates
+	case 1:
+		w.Earlier, w.Later = valid[0], valid[0]
+		return w
+	default:
+		w.Kind = WallClockAmbiguous
+	}
+
+	w.Earlier, w.Later = valid[0], valid[1]
+	if w.Later.Before(w.Earlier) {
+		w.Earlier, w.Later = w.Later, w.Earlier
+	}
+
+	return w
+}
+
+// Pick will return Earlier or Later depending on f.
+// By default, times in a gap are moved forward (Later) and ambiguous times use the first occurrence (Earlier).
+func (w WallClock) Pick(f Fold) time.Time {
+	switch {
+	case f == FoldEarlier:
+		return w.Earlier
+	case f == FoldLater, w.Kind == WallClockGap:
+		return w.Later
+	default:
+		return w.Earlier
+	}
+}
+
+// Warning returns a description of why w is ambiguous and which time was picked by f, or an empty string for normal times
+func (w WallClock) Warning(f Fold) string {
+	picked := w.Pick(f)
+	other, otherFold := w.Later, FoldLater
+	if picked.Equal(w.Later) {
+		other, otherFold = w.Earlier, FoldEarlier
+	}
+
+	switch w.Kind {
+	case WallClockGap:
+		return fmt.Sprintf(
+			"%s doesn't exist in %s (skipped by DST), using %s, use `-fold %s` for %s",
+			w.Wall.Format("2006/01/02 15:04"), picked.Location(), picked.Format("15:04 -07:00"), otherFold, other.Format("15:04 -07:00"),
+		)
+	case WallClockAmbiguous:
+		return fmt.Sprintf(
+			"%s happens twice in %s (repeated by DST), using %s, use `-fold %s` for %s",
+			w.Wall.Format("2006/01/02 15:04"), picked.Location(), picked.Format("15:04 -07:00"), otherFold, other.Format("15:04 -07:00"),
+		)
+	default:
+		return ""
+	}
+}
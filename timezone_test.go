@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveWallClock(t *testing.T) {
+	utc := func(y int, m time.Month, d, h, min int) time.Time { return time.Date(y, m, d, h, min, 0, 0, time.UTC) }
+
+	tests := []struct {
+		loc     string
+		wall    time.Time
+		kind    WallClockKind
+		earlier time.Time
+		later   time.Time
+	}{
+		{"Europe/Berlin", utc(2024, 3, 1, 12, 0), WallClockNormal, utc(2024, 3, 1, 11, 0), utc(2024, 3, 1, 11, 0)},
+		{"Europe/Berlin", utc(2024, 7, 1, 12, 0), WallClockNormal, utc(2024, 7, 1, 10, 0), utc(2024, 7, 1, 10, 0)},
+		{"Europe/Berlin", utc(2024, 3, 31, 1, 59), WallClockNormal, utc(2024, 3, 31, 0, 59), utc(2024, 3, 31, 0, 59)},
+		{"Europe/Berlin", utc(2024, 3, 31, 2, 30), WallClockGap, utc(2024, 3, 31, 0, 30), utc(2024, 3, 31, 1, 30)},
+		{"Europe/Berlin", utc(2024, 3, 31, 3, 0), WallClockNormal, utc(2024, 3, 31, 1, 0), utc(2024, 3, 31, 1, 0)},
+		{"Europe/Berlin", utc(2024, 10, 27, 2, 30), WallClockAmbiguous, utc(2024, 10, 27, 0, 30), utc(2024, 10, 27, 1, 30)},
+		{"Europe/Berlin", utc(2024, 10, 27, 3, 0), WallClockNormal, utc(2024, 10, 27, 2, 0), utc(2024, 10, 27, 2, 0)},
+		{"America/New_York", utc(2024, 3, 10, 2, 30), WallClockGap, utc(2024, 3, 10, 6, 30), utc(2024, 3, 10, 7, 30)},
+		{"America/New_York", utc(2024, 11, 3, 1, 30), WallClockAmbiguous, utc(2024, 11, 3, 5, 30), utc(2024, 11, 3, 6, 30)},
+		{"Australia/Lord_Howe", utc(2024, 10, 6, 2, 15), WallClockGap, utc(2024, 10, 5, 15, 15), utc(2024, 10, 5, 15, 45)},
+		{"UTC", utc(2024, 3, 31, 2, 30), WallClockNormal, utc(2024, 3, 31, 2, 30), utc(2024, 3, 31, 2, 30)},
+	}
+
+	for _, tt := range tests {
+		loc, err := time.LoadLocation(tt.loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := resolveWallClock(tt.wall, loc)
+		if w.Kind != tt.kind || !w.Earlier.Equal(tt.earlier) || !w.Later.Equal(tt.later) {
+			t.Errorf(
+				"resolveWallClock(%v, %s) = %v %v - %v, want %v %v - %v",
+				tt.wall, tt.loc, w.Kind, w.Earlier.UTC(), w.Later.UTC(), tt.kind, tt.earlier, tt.later,
+			)
+		}
+	}
+}
+
+func TestWallClockPick(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gap := resolveWallClock(time.Date(2024, 3, 31, 2, 30, 0, 0, time.UTC), loc)
+	ambiguous := resolveWallClock(time.Date(2024, 10, 27, 2, 30, 0, 0, time.UTC), loc)
+	normal := resolveWallClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), loc)
+
+	tests := []struct {
+		name    string
+		w       WallClock
+		fold    Fold
+		want    time.Time
+		warning string
+	}{
+		{"gap", gap, FoldDefault, gap.Later, "doesn't exist in Europe/Berlin (skipped by DST), using 03:30 +02:00, use `-fold earlier` for 01:30 +01:00"},
+		{"gap", gap, FoldEarlier, gap.Earlier, "using 01:30 +01:00, use `-fold later` for 03:30 +02:00"},
+		{"gap", gap, FoldLater, gap.Later, "using 03:30 +02:00"},
+		{"ambiguous", ambiguous, FoldDefault, ambiguous.Earlier, "happens twice in Europe/Berlin (repeated by DST), using 02:30 +02:00, use `-fold later` for 02:30 +01:00"},
+		{"ambiguous", ambiguous, FoldEarlier, ambiguous.Earlier, "using 02:30 +02:00"},
+		{"ambiguous", ambiguous, FoldLater, ambiguous.Later, "using 02:30 +01:00, use `-fold earlier` for 02:30 +02:00"},
+		{"normal", normal, FoldDefault, normal.Earlier, ""},
+		{"normal", normal, FoldLater, normal.Earlier, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.w.Pick(tt.fold); !got.Equal(tt.want) {
+			t.Errorf("%s: WallClock.Pick(%q) = %v, want %v", tt.name, tt.fold, got, tt.want)
+		}
+
+		warning := tt.w.Warning(tt.fold)
+		if (tt.warning == "") != (warning == "") || !strings.Contains(warning, tt.warning) {
+			t.Errorf("%s: WallClock.Warning(%q) = %q, want it to contain %q", tt.name, tt.fold, warning, tt.warning)
+		}
+	}
+}
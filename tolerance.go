@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	toleranceBaseline = 0.05           // tolerance below this is considered back to baseline
+	toleranceSession  = 12 * time.Hour // doses within this of the first dose of a session only build tolerance once
+)
+
+// ToleranceCurve is how quickly tolerance to a drug or class builds up and decays.
+// Each session adds Build of the tolerance that is left to gain, and tolerance halves every HalfLife.
+type ToleranceCurve struct {
+	Build    float64 `json:"build"`     // between 0 and 1, eg 0.7 for psychedelics where one dose causes most of the tolerance
+	HalfLife string  `json:"half_life"` // eg "3d"
+
+	halfLife time.Duration // generated from HalfLife
+}
+
+// toleranceDefaults are used for drugs and classes that don't have a curve in the config.
+// These are rough estimates from anecdotal reports, and are only meant to show when tolerance is mostly gone.
+var toleranceDefaults = map[string]ToleranceCurve{
+	"psychedelic":   {Build: 0.7, HalfLife: "2d12h"},
+	"trypt":         {Build: 0.7, HalfLife: "2d12h"},
+	"empathogen":    {Build: 0.5, HalfLife: "2w"},
+	"stim":          {Build: 0.15, HalfLife: "2d"},
+	"disso":         {Build: 0.2, HalfLife: "4d"},
+	"benzo":         {Build: 0.1, HalfLife: "5d"},
+	"opiate":        {Build: 0.15, HalfLife: "4d"},
+	"gabapentinoid": {Build: 0.1, HalfLife: "3d"},
+	"cannabinoid":   {Build: 0.1, HalfLife: "1w"},
+	"Caffeine":      {Build: 0.1, HalfLife: "2d"},
+}
+
+func (c *ToleranceCurve) compile(name string) error {
+	if c.Build <= 0 || c.Build > 1 {
+		return fmt.Errorf("tolerance of %s must have a \"build\" between 0 and 1, not %v", name, c.Build)
+	}
+
+	halfLife, err := parseDuration(c.HalfLife)
+	if err != nil || halfLife <= 0 {
+		return fmt.Errorf("tolerance of %s has an invalid \"half_life\" \"%s\", eg \"3d\"", name, c.HalfLife)
+	}
+
+	c.halfLife = halfLife
+	return nil
+}
+
+// At returns the tolerance at now from doses, which must be sorted by time
+func (c ToleranceCurve) At(doses []Dose, now time.Time) float64 {
+	tolerance, last, session := 0.0, time.Time{}, time.Time{}
+
+	for _, d := range doses {
+		if d.Timestamp.After(now) {
+			break
+		}
+
+		if !session.IsZero() && d.Timestamp.Sub(session) < toleranceSession {
+			continue
+		}
+
+		tolerance = c.decay(tolerance, d.Timestamp.Sub(last))
+		tolerance += (1 - tolerance) * c.Build
+		last, session = d.Timestamp, d.Timestamp
+	}
+
+	if last.IsZero() {
+		return 0
+	}
+
+	return c.decay(tolerance, now.Sub(last))
+}
+
+func (c ToleranceCurve) decay(tolerance float64, elapsed time.Duration) float64 {
+	return tolerance * math.Pow(0.5, float64(elapsed)/float64(c.halfLife))
+}
+
+// UntilBaseline returns how long it takes for tolerance to decay to toleranceBaseline
+func (c ToleranceCurve) UntilBaseline(tolerance float64) time.Duration {
+	if tolerance <= toleranceBaseline {
+		return 0
+	}
+
+	return time.Duration(float64(c.halfLife) * math.Log2(tolerance/toleranceBaseline))
+}
+
+// ToleranceCurve returns the curve for a drug or class name from the config, or the defaults
+func (c *Config) ToleranceCurve(name string) (ToleranceCurve, bool) {
+	for n, curve := range c.tolerance {
+		if strings.EqualFold(n, name) {
+			return curve, true
+		}
+	}
+
+	return ToleranceCurve{}, false
+}
+
+// drugToleranceCurve returns the curve of the drug of dose, or the slowest decaying curve of its classes
+func drugToleranceCurve(dose Dose) (ToleranceCurve, bool) {
+	if curve, ok := config.ToleranceCurve(dose.Drug); ok {
+		return curve, true
+	}
+
+	found, ok := ToleranceCurve{}, false
+	for _, name := range config.ClassNames() {
+		curve, hasCurve := config.ToleranceCurve(name)
+		if hasCurve && config.ClassMatches(name, dose, options) && curve.halfLife > found.halfLife {
+			found, ok = curve, true
+		}
+	}
+
+	return found, ok
+}
+
+// Tolerance is a row of -tolerance, for a drug or a cross-tolerant class
+type Tolerance struct {
+	Name      string
+	Drugs     []string // the drugs that contribute to the tolerance of a class
+	Tolerance float64
+	Baseline  time.Duration // until the tolerance is back to baseline
+}
+
+// tolerances returns the tolerance of every drug and class that is above baseline at now, highest first
+func tolerances(doses []Dose, now time.Time) []Tolerance {
+	sorted := make([]Dose, len(doses))
+	copy(sorted, doses)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+
+	rows := make([]Tolerance, 0)
+	add := func(name string, curve ToleranceCurve, matching []Dose, isClass bool) {
+		tolerance := curve.At(matching, now)
+		if tolerance < toleranceBaseline {
+			return
+		}
+
+		row := Tolerance{Name: name, Tolerance: tolerance, Baseline: curve.UntilBaseline(tolerance)}
+
+		// Only list the drugs of a class that were taken recently enough to still count
+		if isClass {
+			since := now.Add(-curve.UntilBaseline(curve.Build))
+			for _, d := range matching {
+				if d.Timestamp.After(since) && !containsFold(row.Drugs, d.Drug) {
+					row.Drugs = append(row.Drugs, d.Drug)
+				}
+			}
+
+			// A class with one drug is the same as the row of the drug, unless it has a different curve
+			for _, r := range rows {
+				if len(row.Drugs) == 1 && r.Name == row.Drugs[0] && r.Tolerance == row.Tolerance {
+					return
+				}
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	drugs := make(map[string][]Dose)
+	order := make([]string, 0)
+	for _, d := range sorted {
+		if _, ok := drugs[d.Drug]; !ok {
+			order = append(order, d.Drug)
+		}
+
+		drugs[d.Drug] = append(drugs[d.Drug], d)
+	}
+
+	for _, drug := range order {
+		if curve, ok := drugToleranceCurve(drugs[drug][0]); ok {
+			add(drug, curve, drugs[drug], false)
+		}
+	}
+
+	// Classes share tolerance between every drug in them, eg LSD and psilocybin
+	for _, class := range config.ClassNames() {
+		curve, ok := config.ToleranceCurve(class)
+		if !ok {
+			continue
+		}
+
+		matching := make([]Dose, 0)
+		for _, d := range sorted {
+			if config.ClassMatches(class, d, options) {
+				matching = append(matching, d)
+			}
+		}
+
+		add(class, curve, matching, true)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].Tolerance > rows[j].Tolerance
+	})
+
+	return rows
+}
+
+// Format returns a summary of t, eg "62% psychedelic (LSD, Psilocybin), baseline in 9d6h (2024/03/12)"
+func (t Tolerance) Format(now time.Time) string {
+	name := t.Name
+	if len(t.Drugs) > 0 {
+		name += " (" + strings.Join(t.Drugs, ", ") + ")"
+	}
+
+	baseline := now.Add(t.Baseline)
+	return fmt.Sprintf("%3.0f%% %s, baseline in %s (%s)", t.Tolerance*100, name, formatDurationDays(t.Baseline.Round(time.Hour)), formatNearTime(baseline, now))
+}
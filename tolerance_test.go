@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestToleranceCurveAt(t *testing.T) {
+	curve := ToleranceCurve{Build: 0.5, HalfLife: "1d"}
+	if err := curve.compile("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	doses := func(after ...time.Duration) []Dose {
+		d := make([]Dose, 0)
+		for _, a := range after {
+			d = append(d, Dose{TimeData: TimeData{Timestamp: start.Add(a)}, Drug: "Test"})
+		}
+		return d
+	}
+
+	tests := []struct {
+		name  string
+		doses []Dose
+		now   time.Duration
+		want  float64
+	}{
+		{"no doses", doses(), 0, 0},
+		{"before the first dose", doses(time.Hour), 0, 0},
+		{"at a dose", doses(0), 0, 0.5},
+		{"one half-life later", doses(0), day, 0.25},
+		{"two half-lives later", doses(0), 2 * day, 0.125},
+		{"same session", doses(0, 6*time.Hour), day, 0.25},
+		{"next session", doses(0, day), day, 0.625},
+		{"next session, a day later", doses(0, day), 2 * day, 0.3125},
+		{"ignores later doses", doses(0, day), 12 * time.Hour, 0.5 * math.Pow(0.5, 0.5)},
+	}
+
+	for _, tt := range tests {
+		if got := curve.At(tt.doses, start.Add(tt.now)); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: ToleranceCurve.At() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToleranceCurveUntilBaseline(t *testing.T) {
+	curve := ToleranceCurve{Build: 0.5, HalfLife: "1d"}
+	if err := curve.compile("Test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		tolerance float64
+		want      time.Duration
+	}{
+		{0, 0},
+		{toleranceBaseline, 0},
+		{toleranceBaseline * 2, 24 * time.Hour},
+		{toleranceBaseline * 8, 72 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := curve.UntilBaseline(tt.tolerance); got != tt.want {
+			t.Errorf("ToleranceCurve.UntilBaseline(%v) = %v, want %v", tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func TestToleranceCurveCompile(t *testing.T) {
+	tests := []struct {
+		curve   ToleranceCurve
+		wantErr bool
+	}{
+		{ToleranceCurve{Build: 0.7, HalfLife: "2d12h"}, false},
+		{ToleranceCurve{Build: 1, HalfLife: "2w"}, false},
+		{ToleranceCurve{Build: 0, HalfLife: "2d"}, true},
+		{ToleranceCurve{Build: 1.5, HalfLife: "2d"}, true},
+		{ToleranceCurve{Build: 0.5, HalfLife: ""}, true},
+		{ToleranceCurve{Build: 0.5, HalfLife: "-2d"}, true},
+		{ToleranceCurve{Build: 0.5, HalfLife: "soon"}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.curve.compile("Test"); (err != nil) != tt.wantErr {
+			t.Errorf("ToleranceCurve%+v.compile() error = %v, wantErr %v", tt.curve, err, tt.wantErr)
+		}
+	}
+}
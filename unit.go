@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UnitFamily separates units that can't be converted between without knowing more about the drug, eg mg and mL
+type UnitFamily int
+
+const (
+	UnitFamilyCount UnitFamily = iota // x, tab, puffs, u, or anything else that isn't known
+	UnitFamilyMass
+	UnitFamilyVolume
+)
+
+func (f UnitFamily) String() string {
+	switch f {
+	case UnitFamilyMass:
+		return "mass"
+	case UnitFamilyVolume:
+		return "volume"
+	default:
+		return "count"
+	}
+}
+
+// Unit is a unit of a dosage, Size is relative to the smallest unit of its family (μg or μL)
+type Unit struct {
+	Name   string
+	Family UnitFamily
+	Size   float64
+}
+
+// units is every unit that can be converted, in order of size within each family.
+// Units that aren't in units are counts, and can only be compared to the same unit.
+var units = []Unit{
+	{"μg", UnitFamilyMass, 1},
+	{"mg", UnitFamilyMass, 1000},
+	{"g", UnitFamilyMass, 1000 * 1000},
+	{"kg", UnitFamilyMass, 1000 * 1000 * 1000},
+	{"μL", UnitFamilyVolume, 1},
+	{"mL", UnitFamilyVolume, 1000},
+	{"L", UnitFamilyVolume, 1000 * 1000},
+}
+
+// unitAliases are other spellings of units, they are always matched case-insensitively
+var unitAliases = map[string]string{
+	"µg":  "μg",
+	"ug":  "μg",
+	"mcg": "μg",
+	"µl":  "μL",
+	"ul":  "μL",
+}
+
+// lookupUnit returns the Unit named s, or a count unit if s isn't a known mass or volume unit
+func lookupUnit(s string) Unit {
+	name := strings.TrimSpace(s)
+	if alias, ok := unitAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+
+	for _, u := range units {
+		// Only volume units are matched case-insensitively, as "ml" is common but "MG" is probably a typo for something
+		if u.Name == name || (u.Family == UnitFamilyVolume && strings.EqualFold(u.Name, name)) {
+			return u
+		}
+	}
+
+	return Unit{Name: name, Family: UnitFamilyCount, Size: 1}
+}
+
+// alcoholUnit is "u" for Alcohol, 1u = 0.1mL of EtOH = 78.945mg at 20°C
+var alcoholUnit = Unit{"u", UnitFamilyMass, 78.945 * 1000}
+
+// lookupDrugUnit returns the Unit named s for drug, this is the same as lookupUnit except for units that depend on the drug
+func lookupDrugUnit(drug, s string) Unit {
+	if strings.TrimSpace(s) == "u" && sameDrug(drug, "Alcohol") {
+		return alcoholUnit
+	}
+
+	return lookupUnit(s)
+}
+
+// Convert returns amount of u in to, or an error if they're in different families
+func (u Unit) Convert(amount float64, to Unit) (float64, error) {
+	if u.Family != to.Family {
+		return amount, fmt.Errorf("can't convert %s (%s) to %s (%s)", u.Name, u.Family, to.Name, to.Family)
+	}
+
+	if u.Family == UnitFamilyCount && u.Name != to.Name {
+		return amount, fmt.Errorf("can't convert %s to %s", u.Name, to.Name)
+	}
+
+	return amount * u.Size / to.Size, nil
+}
+
+// Sensible returns the largest unit in the same family where amount is at least 1, eg 1000μg → 1mg
+func (u Unit) Sensible(amount float64) Unit {
+	if u.Family == UnitFamilyCount || amount == 0 {
+		return u
+	}
+
+	// units is sorted by size, so start at the smallest unit and move up while the amount is still at least 1
+	sensible := Unit{}
+	for _, s := range units {
+		if s.Family == u.Family && (sensible.Name == "" || amount*u.Size/s.Size >= 1) {
+			sensible = s
+		}
+	}
+
+	return sensible
+}
+
+// ConvertTo returns q converted to the unit to, or "auto" for Unit.Sensible.
+// Dosages with a concentration are left as typed, as converting them would lose the meaning of the concentration.
+func (q Quantity) ConvertTo(to string) (Quantity, bool) {
+	if q.Concentration != nil {
+		return q, false
+	}
+
+	// Counts are always shown as typed, as they can only be converted to themselves
+	from := lookupUnit(q.Unit)
+	if from.Family == UnitFamilyCount {
+		return q, false
+	}
+
+	target := lookupUnit(to)
+	if strings.EqualFold(to, "auto") {
+		target = from.Sensible(q.Value)
+	}
+
+	value, err := from.Convert(q.Value, target)
+	if err != nil {
+		return q, false
+	}
+
+	max, _ := from.Convert(q.Max, target)
+
+	q.Value, q.Max, q.Unit = value, max, target.Name
+	return q, true
+}
+
+// String formats q in the same format as it would be typed, eg "2x 5-10mg"
+func (q Quantity) String() string {
+	s := formatAmount(q.Value)
+	if q.Max != 0 {
+		s += "-" + formatAmount(q.Max)
+	}
+
+	if q.Count != 0 {
+		s = formatAmount(q.Count) + "x " + s
+	}
+
+	if lookupUnit(q.Unit).Family == UnitFamilyCount && q.Unit != "x" && q.Unit != "u" && q.Unit != "" {
+		return s + " " + q.Unit
+	}
+
+	return s + q.Unit
+}
+
+// DisplayDosage returns the dosage of d in options.Unit or the preferred unit for the drug in the config.
+// The dosage is shown as typed if it can't be converted.
+func (d Dose) DisplayDosage(options *DisplayOptions) string {
+	unit := options.Unit
+	if unit == "" && options.PreferredUnits {
+		unit = config.PreferredUnit(d.Drug)
+	}
+
+	if unit == "" || d.Dosage == "" {
+		return d.Dosage
+	}
+
+	quantity, err := d.ParsedQuantity()
+	if err != nil {
+		return d.Dosage
+	}
+
+	if converted, ok := quantity.ConvertTo(unit); ok {
+		return converted.String()
+	}
+
+	return d.Dosage
+}
+
+// PreferredUnit returns the unit that drug should be shown in from the config, or an empty string
+func (c *Config) PreferredUnit(drug string) string {
+	for name, unit := range c.Units {
+		if strings.EqualFold(name, drug) {
+			return unit
+		}
+	}
+
+	return ""
+}
+
+// formatDrugAmount formats amount of unit in -unit or the preferred unit of drug, or in a sensible unit otherwise
+func formatDrugAmount(amount float64, unit Unit, drug string) string {
+	name := options.Unit
+	if name == "" {
+		name = config.PreferredUnit(drug)
+	}
+
+	to := lookupUnit(name)
+	if name == "" || to.Family != unit.Family || to.Family == UnitFamilyCount {
+		to = unit.Sensible(amount)
+	}
+
+	converted, err := unit.Convert(amount, to)
+	if err != nil {
+		converted, to = amount, unit
+	}
+
+	return Quantity{Value: converted, Unit: to.Name}.String()
+}